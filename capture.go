@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Capture keeps the decoded session output in memory so it can be saved
+// after the fact. Once max bytes are held, the oldest output is dropped.
+type Capture struct {
+	mu      sync.Mutex
+	buf     []byte
+	max     int
+	dropped int64
+	dirty   bool // data arrived since the last save
+}
+
+// NewCapture returns a capture buffer holding at most max bytes
+func NewCapture(max int) *Capture {
+	return &Capture{max: max}
+}
+
+func (c *Capture) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.buf = append(c.buf, p...)
+	// Trim in steps of an eighth of the cap rather than on every write, so a
+	// full buffer isn't shifted each time the server sends a few bytes
+	if over := len(c.buf) - c.max; over > c.max/8 {
+		c.dropped += int64(over)
+		c.buf = append(c.buf[:0], c.buf[over:]...)
+	}
+	c.dirty = c.dirty || len(p) > 0
+	return len(p), nil
+}
+
+// held returns the newest max bytes and how many older ones were dropped.
+// The caller must hold mu.
+func (c *Capture) held() ([]byte, int64) {
+	if over := len(c.buf) - c.max; over > 0 {
+		return c.buf[over:], c.dropped + int64(over)
+	}
+	return c.buf, c.dropped
+}
+
+// Bytes returns a copy of the captured output
+func (c *Capture) Bytes() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, _ := c.held()
+	return append([]byte(nil), data...)
+}
+
+// Save writes the captured output to path, replacing any existing file
+func (c *Capture) Save(path string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, dropped := c.held()
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	c.dirty = false
+	if dropped > 0 {
+		fmt.Printf("[*] %s\r\n", tr("Saved %d bytes to %s (oldest %d bytes exceeded -capture-max and were dropped)", len(data), path, dropped))
+	} else {
+		fmt.Printf("[+] %s\r\n", tr("Saved %d bytes to %s", len(data), path))
+	}
+	return nil
+}
+
+// promptSave offers to write out a capture that has unsaved output. The
+// caller must have put the terminal back into cooked mode.
+func (c *Capture) promptSave(k *Keyboard) {
+	c.mu.Lock()
+	dirty := c.dirty
+	c.mu.Unlock()
+	if !dirty {
+		return
+	}
+
+	fmt.Print(tr("Save session capture to file (empty to discard): "))
+	name, err := k.ReadLine(nil)
+	name = strings.TrimSpace(name)
+	if err != nil || name == "" {
+		return
+	}
+	if err := c.Save(name); err != nil {
+		fmt.Fprintf(os.Stderr, "[-] %s\n", tr("Failed to save capture: %v", err))
+	}
+}
+
+// parseSize parses a byte count with an optional K, M or G suffix
+func parseSize(v string) (int, error) {
+	s := strings.ToUpper(strings.TrimSpace(v))
+	mult := 1
+	switch {
+	case strings.HasSuffix(s, "K"):
+		mult = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		mult = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		mult = 1 << 30
+	}
+	if mult != 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", v)
+	}
+	if n > math.MaxInt/mult {
+		return 0, fmt.Errorf("size %q is too large", v)
+	}
+	return n * mult, nil
+}
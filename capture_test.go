@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestCaptureKeepsNewest(t *testing.T) {
+	c := NewCapture(64)
+	var all []byte
+	for i := 0; i < 100; i++ {
+		line := []byte(strconv.Itoa(i) + "\r\n")
+		all = append(all, line...)
+		c.Write(line)
+		want := all[max(len(all)-64, 0):]
+		if got := c.Bytes(); !bytes.Equal(got, want) {
+			t.Fatalf("after %d writes: %q, want %q", i+1, got, want)
+		}
+		if _, dropped := c.held(); dropped != int64(len(all)-len(want)) {
+			t.Fatalf("after %d writes: dropped %d, want %d", i+1, dropped, len(all)-len(want))
+		}
+	}
+	// Trimming only happens past the slack, not on every write
+	if len(c.buf) > 64+64/8 {
+		t.Errorf("buffer holds %d bytes, more than max plus slack", len(c.buf))
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+		ok   bool
+	}{
+		{"512", 512, true},
+		{"4k", 4 << 10, true},
+		{" 16M ", 16 << 20, true},
+		{"1G", 1 << 30, true},
+		{"0", 0, false},
+		{"-1K", 0, false},
+		{"lots", 0, false},
+		{"9999999999G", 0, false},
+		{strconv.Itoa(math.MaxInt), math.MaxInt, true},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseSize(%q) = %d, %v; want %d, ok %v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func BenchmarkCaptureFull(b *testing.B) {
+	c := NewCapture(16 << 20)
+	c.Write(make([]byte, 16<<20))
+	chunk := bytes.Repeat([]byte("x"), 512)
+	b.SetBytes(int64(len(chunk)))
+	for i := 0; i < b.N; i++ {
+		c.Write(chunk)
+	}
+}
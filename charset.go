@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"better-telnet/telnet"
+)
+
+// CHARSET subnegotiation commands (RFC 2066)
+const (
+	charsetRequest        = 1
+	charsetAccepted       = 2
+	charsetRejected       = 3
+	charsetTTableIs       = 4
+	charsetTTableRejected = 5
+)
+
+// Canonical names of the character sets we can decode
+const (
+	CharsetUTF8   = "UTF-8"
+	CharsetLatin1 = "ISO-8859-1"
+	CharsetASCII  = "US-ASCII"
+)
+
+// EventCharset reports the outcome of CHARSET negotiation
+const EventCharset = "charset"
+
+var charsetAliases = map[string]string{
+	"UTF-8":      CharsetUTF8,
+	"UTF8":       CharsetUTF8,
+	"ISO-8859-1": CharsetLatin1,
+	"ISO_8859-1": CharsetLatin1,
+	"ISO8859-1":  CharsetLatin1,
+	"LATIN1":     CharsetLatin1,
+	"LATIN-1":    CharsetLatin1,
+	"US-ASCII":   CharsetASCII,
+	"ASCII":      CharsetASCII,
+}
+
+// canonicalCharset maps a charset name to the one we use internally
+func canonicalCharset(name string) (string, bool) {
+	cs, ok := charsetAliases[strings.ToUpper(strings.TrimSpace(name))]
+	return cs, ok
+}
+
+// Charset tracks the character set in use for both directions. It starts
+// out as the -encoding default and may be changed by CHARSET negotiation.
+type Charset struct {
+	mu       sync.Mutex
+	current  string
+	fallback string // The -encoding default we fall back to
+	outcome  string // Human-readable result of negotiation, for status
+
+	events *EventBus
+}
+
+// NewCharset returns a Charset using the given default encoding
+func NewCharset(def string, events *EventBus) *Charset {
+	return &Charset{current: def, fallback: def, outcome: tr("not negotiated"), events: events}
+}
+
+// Current returns the charset in use
+func (c *Charset) Current() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.current
+}
+
+// Status describes the charset and how it was chosen
+func (c *Charset) Status() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return fmt.Sprintf("%s (%s)", c.current, c.outcome)
+}
+
+// accept switches to cs after a successful negotiation
+func (c *Charset) accept(cs string) {
+	c.mu.Lock()
+	c.current = cs
+	c.outcome = tr("negotiated")
+	c.mu.Unlock()
+	c.events.Emit(Event{Kind: EventCharset, Option: telnet.OptionName(telnet.OptCharset), Detail: "accepted " + cs})
+}
+
+// fallBack reverts to the default charset and warns, rather than silently
+// decoding with something the server didn't agree to. The reason is shown
+// translated but kept in English for the event.
+func (c *Charset) fallBack(format string, args ...any) {
+	reason := tr(format, args...)
+	c.mu.Lock()
+	c.current = c.fallback
+	c.outcome = tr("%s, using default", reason)
+	def := c.fallback
+	c.mu.Unlock()
+	fmt.Fprintf(os.Stderr, "\r\n[-] %s\r\n", tr("CHARSET: %s; falling back to %s", reason, def))
+	c.events.Emit(Event{Kind: EventCharset, Option: telnet.OptionName(telnet.OptCharset), Detail: "fallback " + def + ": " + fmt.Sprintf(format, args...)})
+}
+
+// offer lists our charsets, preferred first, for a REQUEST
+func (c *Charset) offer() []string {
+	list := []string{c.fallback}
+	for _, cs := range []string{CharsetUTF8, CharsetLatin1, CharsetASCII} {
+		if cs != c.fallback {
+			list = append(list, cs)
+		}
+	}
+	return list
+}
+
+// Enabled sends our REQUEST once we're allowed to (we said WILL CHARSET)
+func (c *Charset) Enabled(local bool) []byte {
+	if !local {
+		// The server said WILL, so it's the one that will send a REQUEST
+		return nil
+	}
+	payload := append([]byte{charsetRequest, ';'}, strings.Join(c.offer(), ";")...)
+	return telnet.FrameSB(telnet.OptCharset, payload)
+}
+
+// Subnegotiate answers a server REQUEST or handles its reply to ours
+func (c *Charset) Subnegotiate(data []byte) []byte {
+	if len(data) == 0 {
+		return nil
+	}
+	switch data[0] {
+	case charsetRequest:
+		return c.answerRequest(data[1:])
+	case charsetAccepted:
+		name := string(data[1:])
+		if cs, ok := canonicalCharset(name); ok {
+			c.accept(cs)
+		} else {
+			c.fallBack("server chose unsupported charset %q", name)
+		}
+	case charsetRejected:
+		c.fallBack("server rejected all offered charsets")
+	case charsetTTableIs:
+		return telnet.FrameSB(telnet.OptCharset, []byte{charsetTTableRejected})
+	}
+	return nil
+}
+
+// answerRequest picks a charset from the server's list, preferring our default
+func (c *Charset) answerRequest(req []byte) []byte {
+	// An optional "[TTABLE]" marker and version byte may precede the list
+	if rest, ok := bytes.CutPrefix(req, []byte("[TTABLE]")); ok && len(rest) > 0 {
+		req = rest[1:]
+	}
+	if len(req) < 2 {
+		return telnet.FrameSB(telnet.OptCharset, []byte{charsetRejected})
+	}
+	// The first byte is the separator used throughout the list
+	list := strings.Split(string(req[1:]), string(req[0:1]))
+
+	var chosen, chosenName string
+	for _, name := range list {
+		cs, ok := canonicalCharset(name)
+		if !ok {
+			continue
+		}
+		if chosen == "" || cs == c.fallback {
+			chosen, chosenName = cs, name
+		}
+		if cs == c.fallback {
+			break
+		}
+	}
+	if chosen == "" {
+		c.fallBack("server offered only unsupported charsets (%s)", strings.Join(list, ", "))
+		return telnet.FrameSB(telnet.OptCharset, []byte{charsetRejected})
+	}
+	c.accept(chosen)
+	return telnet.FrameSB(telnet.OptCharset, append([]byte{charsetAccepted}, chosenName...))
+}
+
+// decodeWriter converts server output from the current charset to UTF-8
+type decodeWriter struct {
+	w       io.Writer
+	charset *Charset
+	buf     []byte
+	partial []byte // Incomplete UTF-8 sequence held back from the last write
+}
+
+func (d *decodeWriter) Write(p []byte) (int, error) {
+	if d.charset.Current() != CharsetLatin1 {
+		return d.writeUTF8(p)
+	}
+	d.buf = d.buf[:0]
+	for _, b := range p {
+		d.buf = utf8.AppendRune(d.buf, rune(b))
+	}
+	if _, err := d.w.Write(d.buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeUTF8 passes UTF-8 through, holding back a character split across
+// reads until the rest of it arrives, so every write ends on a rune boundary
+// for the terminal and the filters behind it
+func (d *decodeWriter) writeUTF8(p []byte) (int, error) {
+	data := p
+	if len(d.partial) > 0 {
+		data = append(d.partial, p...)
+		d.partial = nil
+	}
+	if n := partialRuneLen(data); n > 0 {
+		d.partial = append([]byte(nil), data[len(data)-n:]...)
+		data = data[:len(data)-n]
+	}
+	if len(data) == 0 {
+		return len(p), nil
+	}
+	if _, err := d.w.Write(data); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// partialRuneLen returns the length of an incomplete UTF-8 sequence at the
+// end of p, or 0 if p ends on a complete (or invalid) character
+func partialRuneLen(p []byte) int {
+	for i := 1; i <= utf8.UTFMax-1 && i <= len(p); i++ {
+		c := p[len(p)-i]
+		if utf8.RuneStart(c) {
+			if c >= utf8.RuneSelf && !utf8.FullRune(p[len(p)-i:]) {
+				return i
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+// encode converts keyboard input (UTF-8) to the current charset
+func (c *Charset) encode(p []byte) []byte {
+	if c.Current() != CharsetLatin1 || !hasHighBytes(p) {
+		return p
+	}
+	out := make([]byte, 0, len(p))
+	for len(p) > 0 {
+		r, size := utf8.DecodeRune(p)
+		if r > 0xff {
+			r = '?'
+		}
+		out = append(out, byte(r))
+		p = p[size:]
+	}
+	return out
+}
+
+// hasHighBytes reports whether p contains anything outside 7-bit ASCII
+func hasHighBytes(p []byte) bool {
+	for _, b := range p {
+		if b >= 0x80 {
+			return true
+		}
+	}
+	return false
+}
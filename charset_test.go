@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"better-telnet/telnet"
+)
+
+// sb frames a CHARSET reply the way Subnegotiate returns it
+func sb(payload ...byte) []byte {
+	return telnet.FrameSB(telnet.OptCharset, payload)
+}
+
+func TestCharsetAnswerRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		def     string
+		request string
+		reply   []byte
+		current string
+	}{
+		{"default preferred", CharsetLatin1, ";UTF-8;ISO-8859-1", sb(append([]byte{charsetAccepted}, "ISO-8859-1"...)...), CharsetLatin1},
+		{"first supported otherwise", CharsetUTF8, ";KOI8-R;latin1;ascii", sb(append([]byte{charsetAccepted}, "latin1"...)...), CharsetLatin1},
+		{"other separator", CharsetUTF8, " KOI8-R utf8", sb(append([]byte{charsetAccepted}, "utf8"...)...), CharsetUTF8},
+		{"TTABLE marker", CharsetUTF8, "[TTABLE]\x01;UTF-8", sb(append([]byte{charsetAccepted}, "UTF-8"...)...), CharsetUTF8},
+		{"only unsupported", CharsetLatin1, ";KOI8-R;EBCDIC-US", sb(charsetRejected), CharsetLatin1},
+		{"empty list", CharsetUTF8, "", sb(charsetRejected), CharsetUTF8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCharset(tt.def, &EventBus{})
+			got := c.Subnegotiate(append([]byte{charsetRequest}, tt.request...))
+			if !bytes.Equal(got, tt.reply) {
+				t.Errorf("reply = %q, want %q", got, tt.reply)
+			}
+			if c.Current() != tt.current {
+				t.Errorf("charset = %s, want %s", c.Current(), tt.current)
+			}
+		})
+	}
+}
+
+// Replies to our own REQUEST: a refusal or an answer we can't use drops
+// back to the -encoding default, and says so
+func TestCharsetFallback(t *testing.T) {
+	tests := []struct {
+		name   string
+		reply  []byte
+		detail string
+	}{
+		{"rejected", []byte{charsetRejected}, "fallback UTF-8: server rejected all offered charsets"},
+		{"unsupported choice", append([]byte{charsetAccepted}, "KOI8-R"...), `fallback UTF-8: server chose unsupported charset "KOI8-R"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bus := &EventBus{}
+			var details []string
+			bus.Subscribe(func(e Event) { details = append(details, e.Detail) })
+			c := NewCharset(CharsetUTF8, bus)
+			// A charset agreed earlier doesn't survive the fallback
+			c.accept(CharsetLatin1)
+			if got := c.Subnegotiate(tt.reply); got != nil {
+				t.Errorf("reply = %q, want none", got)
+			}
+			if c.Current() != CharsetUTF8 {
+				t.Errorf("charset = %s, want the default", c.Current())
+			}
+			if len(details) != 2 || details[1] != tt.detail {
+				t.Errorf("events = %q, want the second to be %q", details, tt.detail)
+			}
+			if !strings.Contains(c.Status(), "default") {
+				t.Errorf("status %q doesn't mention the fallback", c.Status())
+			}
+		})
+	}
+}
+
+func TestCharsetAccepted(t *testing.T) {
+	c := NewCharset(CharsetUTF8, &EventBus{})
+	c.Subnegotiate(append([]byte{charsetAccepted}, "Latin-1"...))
+	if c.Current() != CharsetLatin1 {
+		t.Errorf("charset = %s, want %s", c.Current(), CharsetLatin1)
+	}
+}
+
+func TestCharsetRefusesTTable(t *testing.T) {
+	c := NewCharset(CharsetUTF8, &EventBus{})
+	if got, want := c.Subnegotiate([]byte{charsetTTableIs, 1}), sb(charsetTTableRejected); !bytes.Equal(got, want) {
+		t.Errorf("reply = %q, want %q", got, want)
+	}
+}
+
+// writeRecorder keeps each write separately
+type writeRecorder struct{ writes []string }
+
+func (w *writeRecorder) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, string(p))
+	return len(p), nil
+}
+
+func TestDecodeSplitCharacter(t *testing.T) {
+	// "€" is E2 82 AC; every way of splitting it must reach the terminal whole
+	for _, reads := range [][]string{
+		{"a\xe2", "\x82\xacb"},
+		{"a\xe2\x82", "\xacb"},
+		{"a\xe2", "\x82", "\xacb"},
+		{"a", "\xe2", "\x82", "\xac", "b"},
+	} {
+		out := &writeRecorder{}
+		d := &decodeWriter{w: out, charset: NewCharset(CharsetUTF8, &EventBus{})}
+		for _, r := range reads {
+			if n, err := d.Write([]byte(r)); n != len(r) || err != nil {
+				t.Fatalf("Write(%q) = %d, %v", r, n, err)
+			}
+		}
+		if got := strings.Join(out.writes, ""); got != "a€b" {
+			t.Errorf("%q: output = %q, want \"a€b\"", reads, got)
+		}
+		for _, w := range out.writes {
+			if !utf8.ValidString(w) {
+				t.Errorf("%q: write %q splits a character", reads, w)
+			}
+		}
+	}
+}
+
+func TestDecodeInvalidNotHeld(t *testing.T) {
+	out := &writeRecorder{}
+	d := &decodeWriter{w: out, charset: NewCharset(CharsetUTF8, &EventBus{})}
+	d.Write([]byte("x\xff"))
+	if got := strings.Join(out.writes, ""); got != "x\xff" {
+		t.Errorf("output = %q, want the invalid byte passed through", got)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Escape parser states for cleanLogWriter
+const (
+	cleanText    = iota
+	cleanEsc     // After ESC
+	cleanCSI     // In ESC [ ... final
+	cleanString  // In OSC/DCS/APC/PM, up to BEL or ESC \
+	cleanStrEsc  // ESC seen inside a string
+	cleanCharset // ESC ( and friends take one more byte
+)
+
+// cleanLogWriter turns terminal output into plain text for -logmode clean:
+// escape sequences and control bytes other than LF and tab are dropped and
+// every line starts with an RFC 3339 timestamp. The parser state survives
+// between writes, so a sequence split across two reads is still removed
+// whole instead of leaking its tail into the next line.
+type cleanLogWriter struct {
+	w       io.Writer
+	state   int
+	midLine bool
+}
+
+func newCleanLogWriter(w io.Writer) *cleanLogWriter {
+	return &cleanLogWriter{w: w}
+}
+
+func (c *cleanLogWriter) Write(p []byte) (int, error) {
+	out := make([]byte, 0, len(p)+32)
+	for _, b := range p {
+		switch c.state {
+		case cleanEsc:
+			switch b {
+			case '[':
+				c.state = cleanCSI
+			case ']', 'P', '_', '^':
+				c.state = cleanString
+			case '(', ')', '*', '+', '#':
+				c.state = cleanCharset
+			default:
+				c.state = cleanText // Two-byte sequence such as ESC 7
+			}
+			continue
+		case cleanCSI:
+			if b >= 0x40 && b <= 0x7e {
+				c.state = cleanText
+			}
+			continue
+		case cleanString:
+			if b == 0x07 {
+				c.state = cleanText
+			} else if b == 0x1b {
+				c.state = cleanStrEsc
+			}
+			continue
+		case cleanStrEsc:
+			c.state = cleanString
+			if b == '\\' {
+				c.state = cleanText
+			}
+			continue
+		case cleanCharset:
+			c.state = cleanText
+			continue
+		}
+
+		switch {
+		case b == 0x1b:
+			c.state = cleanEsc
+			continue
+		case b == '\n':
+			if !c.midLine {
+				out = c.stamp(out)
+			}
+			out = append(out, '\n')
+			c.midLine = false
+			continue
+		case (b < 0x20 && b != '\t') || b == 0x7f:
+			continue
+		}
+		if !c.midLine {
+			out = c.stamp(out)
+			c.midLine = true
+		}
+		out = append(out, b)
+	}
+	if _, err := c.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// stamp appends the timestamp that starts a line
+func (c *cleanLogWriter) stamp(out []byte) []byte {
+	out = time.Now().AppendFormat(out, time.RFC3339)
+	return append(out, ' ')
+}
+
+// logSwitch lets command mode pause and resume the -log file. A marker
+// line records each change, so a gap in the log is never silent.
+type logSwitch struct {
+	mu     sync.Mutex
+	w      io.Writer
+	paused bool
+}
+
+func (l *logSwitch) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.paused {
+		return len(p), nil
+	}
+	return l.w.Write(p)
+}
+
+// toggle flips logging and reports whether it is now on
+func (l *logSwitch) toggle() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stamp := time.Now().Format(time.RFC3339)
+	if l.paused {
+		l.paused = false
+		fmt.Fprintf(l.w, "\r\n--- Logging Resumed: %s ---\r\n", stamp)
+	} else {
+		fmt.Fprintf(l.w, "\r\n--- Logging Paused: %s ---\r\n", stamp)
+		l.paused = true
+	}
+	return !l.paused
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+)
+
+// stampRE matches the timestamp cleanLogWriter puts in front of each line
+var stampRE = regexp.MustCompile(`(?m)^\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d(Z|[+-]\d\d:\d\d) `)
+
+// cleanLog feeds writes through a cleanLogWriter and returns the text with
+// timestamps removed, checking that every line got one
+func cleanLog(t *testing.T, writes ...[]byte) string {
+	t.Helper()
+	var out bytes.Buffer
+	c := newCleanLogWriter(&out)
+	for _, w := range writes {
+		if n, err := c.Write(w); err != nil || n != len(w) {
+			t.Fatalf("Write = %d, %v", n, err)
+		}
+	}
+	lines := bytes.Count(out.Bytes(), []byte("\n"))
+	if stamps := len(stampRE.FindAll(out.Bytes(), -1)); stamps != lines {
+		t.Errorf("%d timestamps for %d lines in %q", stamps, lines, out.String())
+	}
+	return stampRE.ReplaceAllString(out.String(), "")
+}
+
+func TestCleanLog(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"CSI colour", "a\x1b[1;31mred\x1b[0mb\n", "aredb\n"},
+		{"CSI private mode", "\x1b[?25lhidden\x1b[?25h\n", "hidden\n"},
+		{"CSI cursor", "x\x1b[10;20Hy\x1b[2K\n", "xy\n"},
+		{"OSC title, BEL", "\x1b]0;router: config\x07#\n", "#\n"},
+		{"OSC hyperlink, ST", "\x1b]8;;http://h/\x1b\\link\x1b]8;;\x1b\\\n", "link\n"},
+		{"DCS", "\x1bP1$r0m\x1b\\ok\n", "ok\n"},
+		{"charset", "\x1b(Bok\n", "ok\n"},
+		{"two-byte", "\x1b7a\x1b8\n", "a\n"},
+		{"controls", "a\rb\x08c\td\x07\n", "abc\td\n"},
+		{"blank lines", "\n\x1b[0m\n", "\n\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := []byte(tt.in)
+			if got := cleanLog(t, in); got != tt.want {
+				t.Fatalf("whole: %q, want %q", got, tt.want)
+			}
+			// Split at every byte position
+			for i := 1; i < len(in); i++ {
+				if got := cleanLog(t, in[:i], in[i:]); got != tt.want {
+					t.Errorf("split at %d (%q | %q): %q, want %q", i, in[:i], in[i:], got, tt.want)
+				}
+			}
+			// And a byte per write
+			var bytewise [][]byte
+			for i := range in {
+				bytewise = append(bytewise, in[i:i+1])
+			}
+			if got := cleanLog(t, bytewise...); got != tt.want {
+				t.Errorf("byte by byte: %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
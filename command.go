@@ -0,0 +1,527 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"better-telnet/telnet"
+	"golang.org/x/term"
+)
+
+// DefaultEscapeChar drops the keyboard pump into the local command prompt
+// (Ctrl+]); -e picks another byte or none
+const DefaultEscapeChar = 0x1D
+
+// NoEscape is the Config.Escape value for "-e none"
+const NoEscape = -1
+
+// CtrlC is the interrupt key, remapped by -interrupt-seq
+const CtrlC = 0x03
+
+// errUserQuit is returned by the keyboard pump when the user quits from command mode
+var errUserQuit = errors.New("quit by user")
+
+// keySequences maps human-friendly key names to the bytes a VT100/xterm sends
+var keySequences = map[string]string{
+	"up":        "\x1b[A",
+	"down":      "\x1b[B",
+	"right":     "\x1b[C",
+	"left":      "\x1b[D",
+	"home":      "\x1b[H",
+	"end":       "\x1b[F",
+	"insert":    "\x1b[2~",
+	"delete":    "\x1b[3~",
+	"pgup":      "\x1b[5~",
+	"pgdn":      "\x1b[6~",
+	"f1":        "\x1bOP",
+	"f2":        "\x1bOQ",
+	"f3":        "\x1bOR",
+	"f4":        "\x1bOS",
+	"f5":        "\x1b[15~",
+	"f6":        "\x1b[17~",
+	"f7":        "\x1b[18~",
+	"f8":        "\x1b[19~",
+	"f9":        "\x1b[20~",
+	"f10":       "\x1b[21~",
+	"f11":       "\x1b[23~",
+	"f12":       "\x1b[24~",
+	"esc":       "\x1b",
+	"tab":       "\t",
+	"enter":     "\r",
+	"backspace": "\x7f",
+}
+
+// Session ties together the connection and local terminal for the keyboard side
+type Session struct {
+	config   Config
+	conn     *telnet.Conn
+	fd       int
+	oldState *term.State
+	keyboard *Keyboard
+	capture  *Capture // nil unless -capture is set
+	options  *OptionTable
+	charset  *Charset
+	history  *History
+	eol      *eolDetector // nil unless -detect-eol is set
+	logging  *logSwitch   // nil unless -log is set
+	recorder *Recorder    // nil unless -record is set
+
+	// Set by "mode cooked": the terminal does line editing and echo, and
+	// serverEcho records that we asked the server to stop echoing
+	cooked     bool
+	serverEcho bool
+
+	// done is closed by main once the session is over
+	done chan struct{}
+}
+
+// pumpKeyboard forwards keystrokes to the server until an error or a quit
+// command. The escape character is intercepted and opens the command prompt.
+func (s *Session) pumpKeyboard() error {
+	// Pre-recorded keystrokes go first, handled exactly like typed ones;
+	// the real keyboard takes over after. Copied, as filtering reuses storage.
+	if len(s.config.Feed) > 0 {
+		if err := s.typed(append([]byte(nil), s.config.Feed...)); err != nil {
+			return err
+		}
+	}
+
+	for {
+		chunk, err := s.keyboard.Next(s.done)
+		if err != nil {
+			return err
+		}
+		if err := s.typed(chunk); err != nil {
+			return err
+		}
+	}
+}
+
+// typed handles a chunk of keyboard input: blocked keys are dropped, the
+// escape character and Ctrl+C act locally and the rest goes to the server
+func (s *Session) typed(chunk []byte) error {
+	// Blocked keys never reach the server or trigger local actions
+	chunk = s.config.BlockKeys.filter(chunk)
+	if s.cooked {
+		// The line discipline turned Enter into LF; telnet wants CR
+		chunk = bytes.ReplaceAll(chunk, []byte{'\n'}, []byte{'\r'})
+	}
+	for {
+		// Rebuilt every time, as "set escape" may have changed it
+		specials := s.specials()
+		i := specials.index(chunk)
+		if i < 0 {
+			break
+		}
+		if err := s.sendKeys(chunk[:i]); err != nil {
+			return err
+		}
+		if int(chunk[i]) == s.config.Escape {
+			rest := chunk[i+1:]
+			// A cooked terminal only delivers the escape along with the
+			// Enter typed after it, which isn't meant for the server
+			if s.cooked && len(rest) > 0 && rest[0] == '\r' {
+				rest = rest[1:]
+			}
+			// Whatever came with the escape (a paste, -feed) is the command
+			// line, and anything after that line goes on to the server
+			s.keyboard.Unread(rest)
+			if s.commandMode() {
+				return errUserQuit
+			}
+			// Taken through typed again: keys left from later reads haven't
+			// been filtered yet, and "mode" may have changed how Enter looks
+			return s.typed(s.keyboard.takePending())
+		}
+		// Sent as is: the sequence may hold telnet commands such as IAC IP
+		if err := s.command(s.config.InterruptSeq); err != nil {
+			return err
+		}
+		chunk = chunk[i+1:]
+	}
+	return s.sendKeys(chunk)
+}
+
+// specials returns the keys the pump handles itself rather than forwarding
+func (s *Session) specials() KeySet {
+	var keys KeySet
+	if s.config.Escape != NoEscape {
+		keys[s.config.Escape] = true
+	}
+	if s.config.InterruptSeq != nil {
+		keys[CtrlC] = true
+	}
+	return keys
+}
+
+// sendKeys forwards typed input, translated with -xlate-out and converted
+// to the server's charset
+func (s *Session) sendKeys(b []byte) error {
+	s.history.track(b)
+	s.recorder.event("i", b)
+	s.echoKeys(b)
+	if s.config.XlateOut != nil {
+		b = s.config.XlateOut.apply(b)
+	}
+	return s.write(s.charset.encode(b))
+}
+
+// echoKeys shows typed input on screen when the server has refused to
+// echo it. A cooked terminal already echoes, and escape sequences such as
+// arrow keys are left out rather than printed as garbage.
+func (s *Session) echoKeys(b []byte) {
+	if s.cooked || len(b) == 0 || b[0] == 0x1b || !s.options.LocalEcho() {
+		return
+	}
+	var echo []byte
+	for _, c := range b {
+		switch {
+		case c == '\r':
+			echo = append(echo, '\r', '\n')
+		case c == 0x7f || c == '\b':
+			echo = append(echo, '\b', ' ', '\b')
+		case c == '\t' || c >= 0x20:
+			echo = append(echo, c)
+		}
+	}
+	fmt.Print(string(echo))
+}
+
+// write sends data to the server, with any IAC bytes escaped. With
+// -char-delay each byte goes out on its own, paced for devices that drop
+// characters arriving too quickly.
+func (s *Session) write(b []byte) error {
+	if s.config.CharDelay <= 0 {
+		_, err := s.conn.Write(b)
+		return err
+	}
+	for i := range b {
+		if _, err := s.conn.Write(b[i : i+1]); err != nil {
+			return err
+		}
+		select {
+		case <-time.After(s.config.CharDelay):
+		case <-s.done:
+			return errSessionDone
+		}
+	}
+	return nil
+}
+
+// command sends bytes that may hold telnet commands, such as IAC BRK, as is
+func (s *Session) command(b []byte) error {
+	_, err := s.conn.WriteRaw(b)
+	return err
+}
+
+// commandMode restores cooked mode, runs a single "telnet>" command and then
+// returns to raw mode. It reports whether the user asked to quit.
+func (s *Session) commandMode() (quit bool) {
+	// No saved state means there's no terminal to switch (as in tests)
+	if s.oldState != nil {
+		term.Restore(s.fd, s.oldState)
+		defer func() {
+			if !s.cooked {
+				term.MakeRaw(s.fd)
+			}
+		}()
+	}
+
+	fmt.Print("\r\ntelnet> ")
+	line, err := s.keyboard.ReadLine(s.done)
+	if err != nil {
+		return true
+	}
+
+	quit, err = s.runCommand(strings.TrimSpace(line))
+	if err != nil {
+		fmt.Printf("[-] %v\n", err)
+	}
+	return quit
+}
+
+// runCommand executes one command-mode line
+func (s *Session) runCommand(line string) (quit bool, err error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return false, nil
+	}
+
+	if fields[0] == "again" || strings.HasPrefix(fields[0], "!") {
+		sent, err := s.history.lookup(fields[0])
+		if err != nil {
+			return false, err
+		}
+		fmt.Println(sent)
+		return false, s.sendKeys([]byte(sent + "\r"))
+	}
+
+	switch fields[0] {
+	case "quit", "q", "close", "c":
+		// There is only ever the one connection, so closing it ends the program
+		return true, nil
+	case "set":
+		if len(fields) != 3 || fields[1] != "escape" {
+			return false, errors.New("usage: set escape <char>|none")
+		}
+		return false, s.setEscape(fields[2])
+	case "toggle":
+		if len(fields) != 2 || fields[1] != "logging" {
+			return false, errors.New("usage: toggle logging")
+		}
+		if s.logging == nil {
+			return false, errors.New(tr("logging is not enabled (start with -log)"))
+		}
+		if s.logging.toggle() {
+			fmt.Println(tr("Logging resumed."))
+		} else {
+			fmt.Println(tr("Logging paused."))
+		}
+		return false, nil
+	case "mode":
+		if len(fields) != 2 {
+			return false, errors.New("usage: mode raw|cooked")
+		}
+		return false, s.setMode(fields[1])
+	case "history":
+		s.history.print()
+		return false, nil
+	case "send":
+		return false, s.runSend(fields[1:], line)
+	case "save":
+		if s.capture == nil {
+			return false, errors.New(tr("capture is not enabled (start with -capture)"))
+		}
+		if len(fields) != 2 {
+			return false, errors.New("usage: save <file>")
+		}
+		return false, s.capture.Save(fields[1])
+	case "sendhex":
+		data, err := hex.DecodeString(strings.Join(fields[1:], ""))
+		if err != nil {
+			return false, errors.New(tr("invalid hex: %v", err))
+		}
+		return false, s.command(data)
+	case "status":
+		s.printStatus()
+		return false, nil
+	case "help", "?":
+		printCommandHelp()
+		return false, nil
+	}
+	return false, errors.New(tr("unknown command %q (try 'help')", fields[0]))
+}
+
+// printStatus shows the connection and negotiated state
+func (s *Session) printStatus() {
+	local, remote := s.options.Active()
+	fmt.Println(tr("Connected to %s", net.JoinHostPort(s.config.Host, s.config.Port)))
+	fmt.Println("  " + tr("Local options:  %s", listOrNone(local)))
+	fmt.Println("  " + tr("Remote options: %s", listOrNone(remote)))
+	fmt.Println("  " + tr("Charset:        %s", s.charset.Status()))
+	fmt.Println("  " + tr("Terminal mode:  %s", s.modeName()))
+	if s.eol != nil {
+		fmt.Println("  " + s.eol.Status())
+	}
+}
+
+// setEscape changes the escape character for the rest of the session
+func (s *Session) setEscape(v string) error {
+	escape, err := parseEscapeChar(v)
+	if err != nil {
+		return err
+	}
+	s.config.Escape = escape
+	if escape == NoEscape {
+		fmt.Println(tr("No escape character. Close the terminal to exit."))
+	} else {
+		fmt.Println(tr("Escape character is '%s'.", caretName(byte(escape))))
+	}
+	return nil
+}
+
+// setMode switches between raw and cooked local terminal handling. Cooked
+// mode gives the terminal's own line editing and echo, e.g. for a long
+// paste; while it lasts the server is asked to stop echoing so lines don't
+// show twice. The switch itself happens when command mode returns.
+func (s *Session) setMode(mode string) error {
+	switch mode {
+	case "cooked":
+		if s.cooked {
+			return nil
+		}
+		s.cooked = true
+		if reply := s.options.RequestRemote(telnet.OptEcho, false); reply != nil {
+			s.serverEcho = true
+			return s.command(reply)
+		}
+	case "raw":
+		if !s.cooked {
+			return nil
+		}
+		s.cooked = false
+		if s.serverEcho {
+			s.serverEcho = false
+			return s.command(s.options.RequestRemote(telnet.OptEcho, true))
+		}
+	default:
+		return errors.New("usage: mode raw|cooked")
+	}
+	return nil
+}
+
+// modeName describes the local terminal mode for status
+func (s *Session) modeName() string {
+	if s.cooked {
+		return tr("cooked (local line editing)")
+	}
+	return tr("raw")
+}
+
+// listOrNone joins names for display, or says "none"
+func listOrNone(names []string) string {
+	if len(names) == 0 {
+		return tr("none")
+	}
+	return strings.Join(names, ", ")
+}
+
+// runSend handles the "send" family of commands
+func (s *Session) runSend(args []string, line string) error {
+	if len(args) == 0 {
+		return errors.New("usage: send key <name> | send esc <rest> | send brk|ayt|ip")
+	}
+
+	switch args[0] {
+	case "brk":
+		return s.command([]byte{telnet.IAC, telnet.BRK})
+	case "ayt":
+		return s.command([]byte{telnet.IAC, telnet.AYT})
+	case "ip":
+		return s.command([]byte{telnet.IAC, telnet.IP})
+	case "key":
+		if len(args) != 2 {
+			return errors.New("usage: send key <name>")
+		}
+		seq, ok := keySequences[strings.ToLower(args[1])]
+		if !ok {
+			return errors.New(tr("unknown key %q (known: %s)", args[1], strings.Join(keyNames(), ", ")))
+		}
+		return s.write([]byte(seq))
+	case "esc":
+		// Everything after "esc" is sent verbatim, so keep the original spacing
+		_, rest, _ := strings.Cut(line, "esc")
+		data, err := unescape(strings.TrimSpace(rest))
+		if err != nil {
+			return err
+		}
+		return s.write(append([]byte{0x1b}, data...))
+	}
+	return errors.New(tr("unknown send target %q", args[0]))
+}
+
+// parseInterruptSeq parses -interrupt-seq: "ip" for IAC IP, or a string
+// with C-style escapes. It returns nil for a plain ETX, which needs no
+// remapping.
+func parseInterruptSeq(v string) ([]byte, error) {
+	if v == "ip" {
+		return []byte{telnet.IAC, telnet.IP}, nil
+	}
+	seq, err := unescape(v)
+	if err != nil {
+		return nil, err
+	}
+	if len(seq) == 0 {
+		return nil, errors.New("empty sequence")
+	}
+	if len(seq) == 1 && seq[0] == CtrlC {
+		return nil, nil
+	}
+	return seq, nil
+}
+
+// keyNames returns the sorted names accepted by "send key"
+func keyNames() []string {
+	names := make([]string, 0, len(keySequences))
+	for name := range keySequences {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// unescape expands C-style escapes (\e, \r, \n, \t, \\, \xNN) in s
+func unescape(s string) ([]byte, error) {
+	var out []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			out = append(out, s[i])
+			continue
+		}
+		i++
+		if i >= len(s) {
+			return nil, errors.New("trailing backslash")
+		}
+		switch s[i] {
+		case 'e':
+			out = append(out, 0x1b)
+		case 'r':
+			out = append(out, '\r')
+		case 'n':
+			out = append(out, '\n')
+		case 't':
+			out = append(out, '\t')
+		case '\\':
+			out = append(out, '\\')
+		case 'x':
+			if i+2 >= len(s) {
+				return nil, errors.New("short \\x escape")
+			}
+			v, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+			if err != nil {
+				return nil, fmt.Errorf("invalid \\x escape %q", s[i-1:i+3])
+			}
+			out = append(out, byte(v))
+			i += 2
+		default:
+			return nil, fmt.Errorf("unknown escape \\%c", s[i])
+		}
+	}
+	return out, nil
+}
+
+// commandHelp lists the commands available at the telnet> prompt
+var commandHelp = [][2]string{
+	{"quit / close", "close the connection and exit"},
+	{"send key <name>", "send the sequence for a named key (up, f1, pgdn, ...)"},
+	{"send esc <rest>", "send ESC followed by <rest> (escapes like \\x1b allowed)"},
+	{"send brk", "send a telnet BREAK (IAC BRK)"},
+	{"send ayt", "ask the server whether it is still there (IAC AYT)"},
+	{"send ip", "send a telnet Interrupt Process (IAC IP)"},
+	{"sendhex <hex>", "send raw bytes given as hex, e.g. \"sendhex 1b 5b 41\""},
+	{"save <file>", "write the -capture buffer to <file>"},
+	{"status", "show connection, option and charset state"},
+	{"mode raw|cooked", "switch local line editing and echo off or on"},
+	{"history", "list the lines typed to the server"},
+	{"set escape <c>", "change the escape character (^X, 0xNN or none)"},
+	{"toggle logging", "pause or resume writing the -log file"},
+	{"!! / again", "resend the last typed line"},
+	{"!N / !-N", "resend line N from history, or the Nth most recent"},
+	{"help", "show this help"},
+}
+
+// printCommandHelp prints commandHelp in the current language
+func printCommandHelp() {
+	fmt.Println(tr("Commands:"))
+	for _, c := range commandHelp {
+		fmt.Printf("  %-17s %s\n", c[0], tr(c[1]))
+	}
+	fmt.Println(tr("Press Enter on an empty line to return to the session."))
+}
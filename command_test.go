@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"better-telnet/telnet"
+)
+
+// keySession returns a Session reading keys from the returned writer, and
+// a function that ends it and returns what reached the server
+func keySession(t *testing.T) (*Session, io.WriteCloser, func() string) {
+	t.Helper()
+	client, server := net.Pipe()
+	t.Cleanup(func() { server.Close() })
+	keys, typing := io.Pipe()
+	t.Cleanup(func() { typing.Close() })
+	options := NewOptionTable(&EventBus{}, time.Now())
+	s := &Session{
+		conn:     telnet.NewConn(client, options.Options),
+		keyboard: NewKeyboard(keys),
+		options:  options,
+		charset:  NewCharset(CharsetUTF8, &EventBus{}),
+		history:  &History{max: DefaultHistorySize},
+		done:     make(chan struct{}),
+	}
+	s.config.Escape = DefaultEscapeChar
+	sent := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(server)
+		sent <- string(b)
+	}()
+	return s, typing, func() string {
+		client.Close()
+		return <-sent
+	}
+}
+
+// A paste or -feed delivers the escape and its command in one chunk
+func TestEscapeCommandInChunk(t *testing.T) {
+	s, _, sent := keySession(t)
+	if err := s.typed([]byte("ab\x1dset escape none\rcd\x1d")); err != nil {
+		t.Fatal(err)
+	}
+	if s.config.Escape != NoEscape {
+		t.Errorf("escape = %d; the command never ran", s.config.Escape)
+	}
+	// The second ^] came after "set escape none", so it is just a key
+	if got := sent(); got != "abcd\x1d" {
+		t.Errorf("sent %q, want \"abcd\\x1d\"", got)
+	}
+}
+
+func TestEscapeCommandInLaterChunks(t *testing.T) {
+	s, typing, sent := keySession(t)
+	go typing.Write([]byte("set esc"))
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		typing.Write([]byte("ape none\rxy"))
+	}()
+	if err := s.typed([]byte("a\x1d")); err != nil {
+		t.Fatal(err)
+	}
+	if s.config.Escape != NoEscape {
+		t.Errorf("escape = %d; the command never ran", s.config.Escape)
+	}
+	if got := sent(); got != "axy" {
+		t.Errorf("sent %q, want \"axy\"", got)
+	}
+}
+
+func TestEscapeQuitInChunk(t *testing.T) {
+	s, _, sent := keySession(t)
+	if err := s.typed([]byte("a\x1dquit\rnot sent")); !errors.Is(err, errUserQuit) {
+		t.Errorf("typed = %v, want errUserQuit", err)
+	}
+	if got := sent(); got != "a" {
+		t.Errorf("sent %q, want \"a\"", got)
+	}
+}
+
+// -feed keystrokes count as typed, so their lines can be resent with !!
+func TestFeedRecordedInHistory(t *testing.T) {
+	s, typing, sent := keySession(t)
+	s.config.Feed = []byte("enable\rshow ver\r")
+	typing.Close()
+	if err := s.pumpKeyboard(); !errors.Is(err, io.EOF) {
+		t.Fatalf("pumpKeyboard = %v, want io.EOF", err)
+	}
+	if want := []string{"enable", "show ver"}; !reflect.DeepEqual(s.history.lines, want) {
+		t.Errorf("history = %q, want %q", s.history.lines, want)
+	}
+	if _, err := s.runCommand("!!"); err != nil {
+		t.Fatal(err)
+	}
+	if got := sent(); got != "enable\rshow ver\rshow ver\r" {
+		t.Errorf("sent %q, want the feed and the repeated line", got)
+	}
+}
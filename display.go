@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// crlfWriter turns bare LF into CRLF on the way to the terminal. The local
+// terminal is in raw mode, so nothing else adds the carriage return and a
+// server that sends Unix line endings would otherwise render as a
+// staircase. Only the display path is normalized; the log sees the bytes
+// exactly as the server sent them.
+type crlfWriter struct {
+	w      io.Writer
+	lastCR bool // The previous write ended in CR
+}
+
+func (c *crlfWriter) Write(p []byte) (int, error) {
+	out := make([]byte, 0, len(p)+8)
+	for _, b := range p {
+		if b == '\n' && !c.lastCR {
+			out = append(out, '\r')
+		}
+		out = append(out, b)
+		c.lastCR = b == '\r'
+	}
+	if _, err := c.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// utf8BOM is the byte order mark some servers put in front of their output
+const utf8BOM = "\xef\xbb\xbf"
+
+// bomWriter drops a BOM at the very start of the stream. It sits behind
+// decodeWriter, which never splits a character, so the first write holds
+// either the whole BOM or none of it.
+type bomWriter struct {
+	w       io.Writer
+	started bool
+}
+
+func (b *bomWriter) Write(p []byte) (int, error) {
+	if b.started || len(p) == 0 {
+		return b.w.Write(p)
+	}
+	b.started = true
+	if _, err := b.w.Write(bytes.TrimPrefix(p, []byte(utf8BOM))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// lineNumberWriter prefixes every line with its number. A bare CR redraws
+// the current line, so the prefix is written again without counting a new
+// line; the number only advances on LF.
+type lineNumberWriter struct {
+	w         io.Writer
+	line      int
+	midLine   bool // The current line's prefix has been written
+	pendingCR bool // Last byte was CR; LF or a redraw decides which
+}
+
+func newLineNumberWriter(w io.Writer) *lineNumberWriter {
+	return &lineNumberWriter{w: w, line: 1}
+}
+
+func (l *lineNumberWriter) Write(p []byte) (int, error) {
+	out := make([]byte, 0, len(p)+16)
+	for _, b := range p {
+		if l.pendingCR && b != '\n' && b != '\r' {
+			l.midLine = false // Same line, drawn over from column 0
+			l.pendingCR = false
+		}
+		// Empty lines are numbered too, so the count matches what is shown
+		if !l.midLine {
+			out = fmt.Appendf(out, "%5d  ", l.line)
+			l.midLine = true
+		}
+		out = append(out, b)
+		switch b {
+		case '\n':
+			l.line++
+			l.midLine = false
+			l.pendingCR = false
+		case '\r':
+			l.pendingCR = true
+		}
+	}
+	if _, err := l.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestBOMWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   string
+	}{
+		{"leading BOM", []string{"\xef\xbb\xbflogin: "}, "login: "},
+		{"leading BOM alone", []string{"\xef\xbb\xbf", "login: "}, "login: "},
+		{"BOM split across reads", []string{"\xef", "\xbb\xbf", "login: "}, "login: "},
+		{"no BOM", []string{"login: "}, "login: "},
+		{"BOM after the start is kept", []string{"a", "\xef\xbb\xbfb"}, "a\xef\xbb\xbfb"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			// As in the session: decoding first, so the BOM arrives whole
+			w := &decodeWriter{w: &bomWriter{w: &out}, charset: NewCharset(CharsetUTF8, &EventBus{})}
+			for _, s := range tt.writes {
+				if _, err := w.Write([]byte(s)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := out.String(); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// The log taps the pipeline serve() builds ahead of the display filters,
+// so it keeps the server's bytes while the screen gets CRLF and loses the
+// BOM
+func TestLogKeepsBytesDisplayNormalized(t *testing.T) {
+	var log, screen bytes.Buffer
+	s := &Session{
+		config:  Config{DisplayCRLF: true, StripBOM: true},
+		charset: NewCharset(CharsetUTF8, &EventBus{}),
+	}
+	w := s.output(&sessionIO{display: &screen, log: &log}, io.Discard)
+	for _, c := range []string{"\xef\xbb\xbfone\ntwo\r", "\nschön\n\r\n"} {
+		if _, err := w.Write([]byte(c)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := log.String(), "\xef\xbb\xbfone\ntwo\r\nschön\n\r\n"; got != want {
+		t.Errorf("log = %q, want %q", got, want)
+	}
+	if got, want := screen.String(), "one\r\ntwo\r\nschön\r\n\r\n"; got != want {
+		t.Errorf("screen = %q, want %q", got, want)
+	}
+}
+
+// Without -display-crlf the screen gets bare LFs too
+func TestDisplayCRLFOff(t *testing.T) {
+	var log, screen bytes.Buffer
+	s := &Session{charset: NewCharset(CharsetUTF8, &EventBus{})}
+	w := s.output(&sessionIO{display: &screen, log: &log}, io.Discard)
+	w.Write([]byte("a\nb\n"))
+	if screen.String() != "a\nb\n" || log.String() != "a\nb\n" {
+		t.Errorf("screen = %q, log = %q; want both unchanged", screen.String(), log.String())
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// eolSample is how many line endings -detect-eol looks at before reporting
+const eolSample = 20
+
+// eolDetector counts the kinds of line ending in the server's early output.
+// It only observes the stream; nothing passing through is changed.
+type eolDetector struct {
+	mu           sync.Mutex
+	lf, crlf, cr int
+	pendingCR    bool
+	reported     bool
+}
+
+func (d *eolDetector) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.reported {
+		return len(p), nil
+	}
+	for _, b := range p {
+		if d.pendingCR {
+			d.pendingCR = false
+			if b == '\n' {
+				d.crlf++
+				continue
+			}
+			d.cr++ // Includes the NVT's CR NUL
+		}
+		switch b {
+		case '\r':
+			d.pendingCR = true
+		case '\n':
+			d.lf++
+		}
+	}
+	if d.lf+d.crlf+d.cr >= eolSample {
+		d.reported = true
+		fmt.Fprintf(os.Stderr, "\r\n[*] %s\r\n", d.summary())
+	}
+	return len(p), nil
+}
+
+// summary describes what has been seen so far. The caller holds mu.
+func (d *eolDetector) summary() string {
+	var kinds []string
+	for _, k := range []struct {
+		name string
+		n    int
+	}{{"CRLF", d.crlf}, {"LF", d.lf}, {"CR", d.cr}} {
+		if k.n > 0 {
+			kinds = append(kinds, fmt.Sprintf("%d %s", k.n, k.name))
+		}
+	}
+	switch len(kinds) {
+	case 0:
+		return tr("Server line endings: none seen yet")
+	case 1:
+		return tr("Server line endings: %s", strings.SplitN(kinds[0], " ", 2)[1])
+	}
+	return tr("Server line endings are inconsistent: %s", strings.Join(kinds, ", "))
+}
+
+// finish reports a short session that never filled the sample
+func (d *eolDetector) finish() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.reported && d.lf+d.crlf+d.cr > 0 {
+		d.reported = true
+		fmt.Fprintf(os.Stderr, "[*] %s\r\n", d.summary())
+	}
+}
+
+// Status returns the current finding for the status command
+func (d *eolDetector) Status() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.summary()
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"better-telnet/telnet"
+)
+
+// Event kinds
+const (
+	EventOptionEnabled  = "option-enabled"
+	EventOptionDisabled = "option-disabled"
+	EventProtocolError  = "protocol-error" // Malformed or abusive input from the server
+)
+
+// Event describes something notable that happened during the session
+type Event struct {
+	Time   time.Time
+	Kind   string
+	Option string // Option name for option events
+	Detail string
+
+	// Elapsed is the time since the connection was established, for
+	// events raised by the negotiation layer
+	Elapsed time.Duration
+}
+
+// EventBus fans events out to every subscriber. A nil bus drops events.
+type EventBus struct {
+	mu       sync.Mutex
+	handlers []func(Event)
+}
+
+// Subscribe registers fn to be called for every future event
+func (b *EventBus) Subscribe(fn func(Event)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, fn)
+}
+
+// Emit timestamps e and delivers it to all subscribers
+func (b *EventBus) Emit(e Event) {
+	if b == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b.mu.Lock()
+	handlers := b.handlers
+	b.mu.Unlock()
+	for _, fn := range handlers {
+		fn(e)
+	}
+}
+
+// OptionHook runs Command whenever Option becomes active, in either direction
+type OptionHook struct {
+	Option  byte
+	Command string
+}
+
+// parseOptionHook parses a "NAME:command" -on-option value
+func parseOptionHook(s string) (OptionHook, error) {
+	name, command, ok := strings.Cut(s, ":")
+	if !ok || strings.TrimSpace(command) == "" {
+		return OptionHook{}, fmt.Errorf("invalid -on-option %q (want OPTION:command)", s)
+	}
+	opt, err := telnet.ParseOption(name)
+	if err != nil {
+		return OptionHook{}, err
+	}
+	return OptionHook{Option: opt, Command: command}, nil
+}
+
+// hookOptions returns the options the hooks watch. We agree to them, as a
+// hook on an option we refuse could never run.
+func hookOptions(hooks []OptionHook) []byte {
+	opts := make([]byte, len(hooks))
+	for i, h := range hooks {
+		opts[i] = h.Option
+	}
+	return opts
+}
+
+// installOptionHooks subscribes the -on-option hooks to the event bus
+func installOptionHooks(bus *EventBus, hooks []OptionHook, config Config) {
+	if len(hooks) == 0 {
+		return
+	}
+	bus.Subscribe(func(e Event) {
+		if e.Kind != EventOptionEnabled {
+			return
+		}
+		for _, h := range hooks {
+			if telnet.OptionName(h.Option) != e.Option {
+				continue
+			}
+			runHook(h.Command,
+				"BTEL_EVENT="+e.Kind,
+				"BTEL_OPTION="+e.Option,
+				"BTEL_SIDE="+e.Detail,
+				"BTEL_ELAPSED_MS="+strconv.FormatInt(e.Elapsed.Milliseconds(), 10),
+				"BTEL_HOST="+config.Host,
+				"BTEL_PORT="+config.Port,
+			)
+		}
+	})
+}
+
+// notify alerts the user that something happened: it runs -notify-cmd if
+// one is configured, and rings the terminal bell otherwise
+func notify(config Config, event string) {
+	if config.NotifyCmd != "" {
+		runHook(config.NotifyCmd,
+			"BTEL_EVENT="+event,
+			"BTEL_HOST="+config.Host,
+			"BTEL_PORT="+config.Port,
+		)
+		return
+	}
+	fmt.Print("\a")
+}
+
+// runHook starts command through the platform shell without waiting for it.
+// Its output is discarded so it can't scribble over the raw-mode session.
+func runHook(command string, env ...string) {
+	cmd := shellCommand(command)
+	cmd.Env = append(os.Environ(), env...)
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "\r\n[-] %s\r\n", tr("Hook %q failed: %v", command, err))
+		return
+	}
+	go cmd.Wait()
+}
+
+// shellCommand wraps a command line in the platform's shell
+func shellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("sh", "-c", command)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"better-telnet/telnet"
+)
+
+func TestParseOptionHook(t *testing.T) {
+	h, err := parseOptionHook("gmcp:notify-send GMCP")
+	if err != nil || h.Option != telnet.OptGMCP || h.Command != "notify-send GMCP" {
+		t.Errorf("parseOptionHook = %+v, %v", h, err)
+	}
+	for _, bad := range []string{"GMCP", "GMCP: ", "NOSUCH:cmd"} {
+		if _, err := parseOptionHook(bad); err == nil {
+			t.Errorf("parseOptionHook(%q) succeeded", bad)
+		}
+	}
+}
+
+// A hooked option the client has no handler for still gets enabled, so
+// its hook can fire
+func TestHookedOptionIsAccepted(t *testing.T) {
+	hooks := []OptionHook{{Option: telnet.OptGMCP, Command: "true"}}
+	bus := &EventBus{}
+	var enabled []string
+	bus.Subscribe(func(e Event) {
+		if e.Kind == EventOptionEnabled {
+			enabled = append(enabled, e.Option)
+		}
+	})
+	options := NewOptionTable(bus, time.Now())
+	options.accept(hookOptions(hooks))
+	negotiate(t, options, []byte{telnet.IAC, telnet.WILL, telnet.OptGMCP})
+	if len(enabled) != 1 || enabled[0] != "GMCP" {
+		t.Errorf("enabled = %v, want [GMCP]", enabled)
+	}
+}
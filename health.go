@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"better-telnet/telnet"
+)
+
+// ErrPeerDead is reported when the server ignores a health-check probe
+var ErrPeerDead = errors.New("server did not answer the health check")
+
+// healthMinTick bounds how often an idle connection is looked at
+const healthMinTick = 10 * time.Millisecond
+
+// healthCheck probes an idle connection at the telnet level. TCP keepalive
+// only proves the peer's kernel is alive; a wedged telnetd or console
+// server still has to answer AYT or TIMING-MARK itself.
+type healthCheck struct {
+	idle    time.Duration // Quiet time before probing
+	timeout time.Duration // How long to wait for any reply
+	probe   []byte
+
+	last atomic.Int64 // UnixNano of the last byte received
+}
+
+// newHealthCheck builds a checker using "timing-mark" or "ayt" probes
+func newHealthCheck(idle, timeout time.Duration, probe string) (*healthCheck, error) {
+	h := &healthCheck{idle: idle, timeout: timeout}
+	switch probe {
+	case "timing-mark":
+		// The WILL/WONT reply is invisible, unlike most AYT answers
+		h.probe = []byte{telnet.IAC, telnet.DO, telnet.OptTimingMark}
+	case "ayt":
+		h.probe = []byte{telnet.IAC, telnet.AYT}
+	default:
+		return nil, fmt.Errorf("invalid health probe %q (want timing-mark or ayt)", probe)
+	}
+	h.touch()
+	return h, nil
+}
+
+// touch records that the server sent something
+func (h *healthCheck) touch() {
+	h.last.Store(time.Now().UnixNano())
+}
+
+// run probes through w whenever the connection has been idle too long and
+// reports ErrPeerDead on errs if a probe goes unanswered
+func (h *healthCheck) run(w io.Writer, errs chan<- error, done <-chan struct{}) {
+	// Floored, as a tiny -health-check would otherwise make NewTicker panic
+	ticker := time.NewTicker(max(min(h.idle, h.timeout)/4, healthMinTick))
+	defer ticker.Stop()
+
+	var probedAt time.Time
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			last := time.Unix(0, h.last.Load())
+			if !probedAt.IsZero() {
+				if last.After(probedAt) {
+					probedAt = time.Time{} // Still alive
+				} else if now.Sub(probedAt) >= h.timeout {
+					errs <- ErrPeerDead
+					return
+				}
+				continue
+			}
+			if now.Sub(last) >= h.idle {
+				if _, err := w.Write(h.probe); err != nil {
+					errs <- err
+					return
+				}
+				probedAt = now
+			}
+		}
+	}
+}
+
+// activityConn notes every successful read for the health check
+type activityConn struct {
+	net.Conn
+	h *healthCheck
+}
+
+func (a *activityConn) Read(p []byte) (int, error) {
+	n, err := a.Conn.Read(p)
+	if n > 0 {
+		a.h.touch()
+	}
+	return n, err
+}
+
+// keepalive sends IAC NOP through w every interval. Servers ignore it, but
+// it keeps NAT and firewall state alive on an idle link, and once TCP gives
+// up on a dead peer the next NOP fails and reports it on errs.
+func keepalive(w io.Writer, interval time.Duration, errs chan<- error, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if _, err := w.Write([]byte{telnet.IAC, telnet.NOP}); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}
+}
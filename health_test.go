@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"better-telnet/telnet"
+)
+
+// probeServer runs a health check against the server end of a pipe, wired
+// as serve() wires it. answer decides the reply to each probe (nil for
+// none); every probe received is sent on probes.
+func probeServer(t *testing.T, h *healthCheck, answer []byte) (errs chan error, probes chan []byte, reads chan error) {
+	t.Helper()
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		close(done)
+		client.Close()
+		server.Close()
+	})
+	tconn := telnet.NewConn(&activityConn{Conn: client, h: h}, telnet.NewOptions())
+	tconn.MaxNegotiations = 3
+
+	probes = make(chan []byte, 100)
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, err := server.Read(buf)
+			if err != nil {
+				return
+			}
+			select {
+			case probes <- append([]byte(nil), buf[:n]...):
+			default:
+			}
+			if answer != nil {
+				server.Write(answer)
+			}
+		}
+	}()
+	reads = make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, tconn)
+		reads <- err
+	}()
+	errs = make(chan error, 1)
+	go h.run(tconn.Raw(), errs, done)
+	return errs, probes, reads
+}
+
+func TestHealthCheckSilentServer(t *testing.T) {
+	h, err := newHealthCheck(20*time.Millisecond, 50*time.Millisecond, "timing-mark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs, probes, _ := probeServer(t, h, nil)
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrPeerDead) {
+			t.Errorf("error = %v, want ErrPeerDead", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("an unanswered probe was not reported")
+	}
+	if got, want := <-probes, []byte{telnet.IAC, telnet.DO, telnet.OptTimingMark}; !bytes.Equal(got, want) {
+		t.Errorf("probe = %v, want %v", got, want)
+	}
+}
+
+// A server answering every probe stays up, however many probes that takes
+func TestHealthCheckAnsweringServer(t *testing.T) {
+	h, err := newHealthCheck(healthMinTick, 200*time.Millisecond, "timing-mark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs, probes, reads := probeServer(t, h, []byte{telnet.IAC, telnet.WONT, telnet.OptTimingMark})
+	// Well past MaxNegotiations, which the acks must not count toward
+	for i := 0; i < 10; i++ {
+		select {
+		case <-probes:
+		case err := <-errs:
+			t.Fatalf("health check failed after %d probes: %v", i, err)
+		case err := <-reads:
+			t.Fatalf("connection failed after %d probes: %v", i, err)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("only %d probes sent", i)
+		}
+	}
+}
+
+func TestHealthProbes(t *testing.T) {
+	tests := []struct {
+		probe string
+		want  []byte
+	}{
+		{"timing-mark", []byte{telnet.IAC, telnet.DO, telnet.OptTimingMark}},
+		{"ayt", []byte{telnet.IAC, telnet.AYT}},
+	}
+	for _, tt := range tests {
+		h, err := newHealthCheck(time.Second, time.Second, tt.probe)
+		if err != nil || !bytes.Equal(h.probe, tt.want) {
+			t.Errorf("%s: probe = %v, %v; want %v", tt.probe, h.probe, err, tt.want)
+		}
+	}
+	if _, err := newHealthCheck(time.Second, time.Second, "ping"); err == nil {
+		t.Error("an unknown probe was accepted")
+	}
+}
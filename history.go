@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DefaultHistorySize bounds how many sent lines command mode remembers
+const DefaultHistorySize = 100
+
+// History remembers the lines typed to the server so command mode can
+// resend them. Lines are reconstructed from keystrokes: printable bytes are
+// collected, backspace and Ctrl+U edit the pending line and Enter commits
+// it. Once an escape sequence (arrow keys, remote line editing) shows up the
+// local copy can no longer be trusted, so that line is not recorded.
+//
+// Keystrokes are tracked whether typed or fed with -feed; "send" and
+// "sendhex" bypass it.
+// History is only touched from the keyboard goroutine.
+type History struct {
+	lines   []string
+	max     int
+	path    string // Persisted on Save when non-empty
+	pending []byte
+	garbled bool
+}
+
+// loadHistory returns a history bounded to max lines, preloaded from path
+// when it names an existing file
+func loadHistory(path string, max int) (*History, error) {
+	h := &History{max: max, path: path}
+	if path == "" {
+		return h, nil
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			h.add(line)
+		}
+	}
+	return h, scanner.Err()
+}
+
+// add appends a line, dropping the oldest once full
+func (h *History) add(line string) {
+	h.lines = append(h.lines, line)
+	if len(h.lines) > h.max {
+		h.lines = h.lines[len(h.lines)-h.max:]
+	}
+}
+
+// track follows typed bytes and records each completed line
+func (h *History) track(b []byte) {
+	for _, c := range b {
+		switch {
+		case c == '\r' || c == '\n':
+			if len(h.pending) > 0 && !h.garbled {
+				h.add(string(h.pending))
+			}
+			h.pending = h.pending[:0]
+			h.garbled = false
+		case c == 0x7f || c == 0x08:
+			if len(h.pending) > 0 {
+				h.pending = h.pending[:len(h.pending)-1]
+			}
+		case c == 0x15: // Ctrl+U
+			h.pending = h.pending[:0]
+			h.garbled = false
+		case c == 0x1b:
+			h.garbled = true
+		case c >= 0x20 || c == '\t':
+			h.pending = append(h.pending, c)
+		}
+	}
+}
+
+// lookup resolves "!!", "again", "!N" (as numbered by "history") and "!-N"
+// (the Nth most recent line)
+func (h *History) lookup(ref string) (string, error) {
+	if len(h.lines) == 0 {
+		return "", errors.New(tr("history is empty"))
+	}
+	if ref == "!!" || ref == "again" {
+		return h.lines[len(h.lines)-1], nil
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(ref, "!"))
+	if err != nil || n == 0 {
+		return "", errors.New(tr("invalid history reference %q", ref))
+	}
+	i := n - 1
+	if n < 0 {
+		i = len(h.lines) + n
+	}
+	if i < 0 || i >= len(h.lines) {
+		return "", errors.New(tr("no history entry %s", ref))
+	}
+	return h.lines[i], nil
+}
+
+// print lists the history with the numbers "!N" accepts
+func (h *History) print() {
+	if len(h.lines) == 0 {
+		fmt.Println(tr("history is empty"))
+		return
+	}
+	for i, line := range h.lines {
+		fmt.Printf("%4d  %s\n", i+1, line)
+	}
+}
+
+// Save writes the history back to its file, if it has one
+func (h *History) Save() error {
+	if h.path == "" {
+		return nil
+	}
+	var b strings.Builder
+	for _, line := range h.lines {
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	return os.WriteFile(h.path, []byte(b.String()), 0o600)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// lang is the language used for BetterTelnet's own messages. Server data
+// is never translated.
+var lang = "en"
+
+// catalogs maps a language to translations keyed by the English format
+// string, so untranslated messages simply fall back to English
+var catalogs = map[string]map[string]string{
+	"zh": {
+		// Connection lifecycle
+		"Connecting to %s...":                                      "正在连接 %s...",
+		"Connecting to %s via tunnel command...":                   "正在通过隧道命令连接 %s...",
+		"Connection failed: %v":                                    "连接失败：%v",
+		"Failed to set raw mode: %v":                               "无法设置原始模式：%v",
+		"Failed to open log file: %v":                              "无法打开日志文件：%v",
+		"Connected to %s":                                          "已连接到 %s",
+		"Escape character is blocked. Close the terminal to exit.": "转义字符已被屏蔽。关闭终端以退出。",
+		"Connection closed.":                                       "连接已关闭。",
+		"Disconnecting: %v":                                        "正在断开：%v",
+		"Hook %q failed: %v":                                       "钩子 %q 执行失败：%v",
+		"Failed to read feed file: %v":                             "无法读取输入文件：%v",
+
+		// Capture
+		"Saved %d bytes to %s": "已保存 %d 字节到 %s",
+		"Saved %d bytes to %s (oldest %d bytes exceeded -capture-max and were dropped)": "已保存 %d 字节到 %s（最早的 %d 字节超出 -capture-max 已被丢弃）",
+		"Save session capture to file (empty to discard): ":                             "将会话记录保存到文件（留空则丢弃）：",
+		"Failed to save capture: %v":                                                    "保存会话记录失败：%v",
+
+		// Charset
+		"CHARSET: %s; falling back to %s":               "字符集协商：%s；回退到 %s",
+		"not negotiated":                                "未协商",
+		"negotiated":                                    "已协商",
+		"%s, using default":                             "%s，使用默认值",
+		"server chose unsupported charset %q":           "服务器选择了不支持的字符集 %q",
+		"server rejected all offered charsets":          "服务器拒绝了所有提供的字符集",
+		"server offered only unsupported charsets (%s)": "服务器仅提供不支持的字符集（%s）",
+		"Connection closed by foreign host.":            "连接已被远程主机关闭。",
+
+		// Zmodem
+		"Zmodem transfer detected, receiving into %s": "检测到 Zmodem 传输，接收到 %s",
+		"Zmodem: receiving %s (%d bytes)":             "Zmodem：正在接收 %s（%d 字节）",
+		"Zmodem: saved %s (%d bytes)":                 "Zmodem：已保存 %s（%d 字节）",
+		"Zmodem: discarded incomplete %s":             "Zmodem：已丢弃不完整的文件 %s",
+		"Zmodem: skipping file with unusable name %q": "Zmodem：跳过文件名无效的文件 %q",
+		"Zmodem transfer failed: %v":                  "Zmodem 传输失败：%v",
+		"Zmodem transfer finished, resuming session":  "Zmodem 传输完成，恢复会话",
+
+		// Command mode
+		"Commands:": "命令：",
+		"Press Enter on an empty line to return to the session.":   "在空行按回车返回会话。",
+		"close the connection and exit":                            "关闭连接并退出",
+		"send the sequence for a named key (up, f1, pgdn, ...)":    "发送指定按键的序列（up、f1、pgdn 等）",
+		"send ESC followed by <rest> (escapes like \\x1b allowed)": "发送 ESC 及其后的 <rest>（支持 \\x1b 等转义）",
+		"send raw bytes given as hex, e.g. \"sendhex 1b 5b 41\"":   "以十六进制发送原始字节，如 \"sendhex 1b 5b 41\"",
+		"write the -capture buffer to <file>":                      "将 -capture 缓冲区写入 <file>",
+		"show connection, option and charset state":                "显示连接、选项和字符集状态",
+		"show this help":                  "显示此帮助",
+		"Local options:  %s":              "本地选项：  %s",
+		"Remote options: %s":              "远端选项：  %s",
+		"Charset:        %s":              "字符集：    %s",
+		"none":                            "无",
+		"unknown command %q (try 'help')": "未知命令 %q（输入 'help' 查看帮助）",
+		"capture is not enabled (start with -capture)": "未启用会话记录（请使用 -capture 启动）",
+		"invalid hex: %v":                 "十六进制无效：%v",
+		"unknown key %q (known: %s)":      "未知按键 %q（可用：%s）",
+		"unknown send target %q":          "未知的 send 目标 %q",
+		"Failed to write option dump: %v": "无法写入选项状态文件：%v",
+		"No reply to the health check within %s, connection presumed dead.":         "健康检查在 %s 内未收到回应，连接可能已断开。",
+		"Invalid translation table: %v":                                             "转换表无效：%v",
+		"history is empty":                                                          "历史记录为空",
+		"invalid history reference %q":                                              "无效的历史记录引用 %q",
+		"no history entry %s":                                                       "没有历史记录 %s",
+		"Failed to read history file: %v":                                           "读取历史记录文件失败：%v",
+		"Failed to save history: %v":                                                "保存历史记录失败：%v",
+		"list the lines typed to the server":                                        "列出已发送给服务器的输入行",
+		"resend the last typed line":                                                "重新发送上一行输入",
+		"resend line N from history, or the Nth most recent":                        "重新发送历史记录第 N 行，或倒数第 N 行",
+		"New TLS certificate for %s (%s), saved to %s":                              "%s 的新 TLS 证书（%s），已保存到 %s",
+		"WARNING: THE TLS CERTIFICATE OF %s HAS CHANGED!":                           "警告：%s 的 TLS 证书已改变！",
+		"Someone could be intercepting the connection, or the device was re-keyed.": "可能有人正在拦截连接，也可能是设备更换了证书。",
+		"Pinned:    %s":                                                             "已固定：%s",
+		"Presented: %s":                                                             "当前：  %s",
+		"Pass -tls-accept-new to trust the new certificate (known hosts: %s).":      "如需信任新证书，请使用 -tls-accept-new（已知主机文件：%s）。",
+		"Replacing pinned TLS certificate for %s with %s":                           "将 %s 的固定 TLS 证书替换为 %s",
+		"Failed to write transcript: %v":                                            "写入会话记录失败：%v",
+		"Transcript written to %s":                                                  "会话记录已写入 %s",
+		"Invalid script: %v":                                                        "脚本无效：%v",
+		"Connection to %s failed: %v":                                               "连接 %s 失败：%v",
+		"Trying %s...":                                                              "正在尝试 %s...",
+		"%d addresses found:":                                                       "共解析到 %d 个地址：",
+		"(failed)":                                                                  "（失败）",
+		"Address to try [1-%d, empty to give up]: ":                                 "要尝试的地址 [1-%d，留空放弃]：",
+		"Failed to send to server: %v":                                              "发送到服务器失败：%v",
+		"Protocol error (%s): %s":                                                   "协议错误（%s）：%s",
+		"cooked (local line editing)":                                               "cooked（本地行编辑）",
+		"raw":                                                                       "raw",
+		"Terminal mode:  %s":                                                        "终端模式：  %s",
+		"switch local line editing and echo off or on":                              "关闭或开启本地行编辑与回显",
+		"Server line endings: none seen yet":                                        "服务器换行符：尚未检测到",
+		"Server line endings: %s":                                                   "服务器换行符：%s",
+		"Server line endings are inconsistent: %s":                                  "服务器换行符不一致：%s",
+		"No escape character. Close the terminal to exit.":                          "未设置转义字符。关闭终端即可退出。",
+		"Escape character is '%s'.":                                                 "转义字符为 '%s'。",
+		"send a telnet BREAK (IAC BRK)":                                             "发送 Telnet BREAK（IAC BRK）",
+		"logging is not enabled (start with -log)":                                  "日志未启用（请使用 -log 启动）",
+		"Logging resumed.":                                                          "已恢复写入日志。",
+		"Logging paused.":                                                           "已暂停写入日志。",
+		"ask the server whether it is still there (IAC AYT)":                        "询问服务器是否仍在响应（IAC AYT）",
+		"send a telnet Interrupt Process (IAC IP)":                                  "发送 telnet 中断进程命令（IAC IP）",
+		"change the escape character (^X, 0xNN or none)":                            "更改转义字符（^X、0xNN 或 none）",
+		"pause or resume writing the -log file":                                     "暂停或恢复写入 -log 日志文件",
+		"Connecting to %s via proxy %s...":                                          "正在通过代理 %[2]s 连接 %[1]s...",
+		"TLS certificate verification is disabled (-tls-skip-verify)":               "已关闭 TLS 证书验证（-tls-skip-verify）",
+		"Failed to start recording: %v":                                             "无法开始录制：%v",
+		"Recording incomplete: %v":                                                  "录制文件不完整：%v",
+		"Replay failed: %v":                                                         "回放失败：%v",
+		"closed by the server":                                                      "被服务器关闭",
+		"Connection lost (%s).":                                                     "连接已断开（%s）。",
+		"Reconnected to %s, session restored.":                                      "已重新连接到 %s，会话已恢复。",
+		"Reconnecting in %s...":                                                     "%s 后重新连接...",
+		"Reconnect failed: %v":                                                      "重新连接失败: %v",
+	},
+}
+
+// tr formats a message in the current language
+func tr(format string, args ...any) string {
+	if t, ok := catalogs[lang][format]; ok {
+		format = t
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// setLanguage selects the message language from a -lang value, falling
+// back to the usual locale variables when it is empty
+func setLanguage(tag string) {
+	if tag == "" {
+		for _, env := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+			if tag = os.Getenv(env); tag != "" {
+				break
+			}
+		}
+	}
+	// "zh_CN.UTF-8" -> "zh"
+	tag = strings.ToLower(tag)
+	if i := strings.IndexAny(tag, "_-."); i >= 0 {
+		tag = tag[:i]
+	}
+	if _, ok := catalogs[tag]; ok {
+		lang = tag
+	} else {
+		lang = "en"
+	}
+}
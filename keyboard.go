@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// errSessionDone is returned by Keyboard reads once the session has ended
+var errSessionDone = errors.New("session ended")
+
+// Keyboard reads stdin on its own goroutine so the session pump, command mode
+// and end-of-session prompts can take turns consuming keystrokes without
+// racing each other for a blocked Read.
+type Keyboard struct {
+	chunks  chan []byte
+	err     error // valid once chunks is closed
+	pending []byte
+}
+
+// NewKeyboard starts reading r in the background
+func NewKeyboard(r io.Reader) *Keyboard {
+	k := &Keyboard{chunks: make(chan []byte)}
+	go func() {
+		for {
+			buf := make([]byte, 1024)
+			n, err := r.Read(buf)
+			if n > 0 {
+				k.chunks <- buf[:n]
+			}
+			if err != nil {
+				k.err = err
+				close(k.chunks)
+				return
+			}
+		}
+	}()
+	return k
+}
+
+// Next returns the next chunk of input. It gives up with errSessionDone when
+// done is closed; a nil done channel waits indefinitely.
+func (k *Keyboard) Next(done <-chan struct{}) ([]byte, error) {
+	if len(k.pending) > 0 {
+		p := k.pending
+		k.pending = nil
+		return p, nil
+	}
+	select {
+	case c, ok := <-k.chunks:
+		if !ok {
+			return nil, k.err
+		}
+		return c, nil
+	case <-done:
+		return nil, errSessionDone
+	}
+}
+
+// Unread pushes b back so the next call to Next returns it first
+func (k *Keyboard) Unread(b []byte) {
+	if len(b) > 0 {
+		k.pending = append(append([]byte(nil), b...), k.pending...)
+	}
+}
+
+// takePending returns and forgets the input pushed back with Unread
+func (k *Keyboard) takePending() []byte {
+	p := k.pending
+	k.pending = nil
+	return p
+}
+
+// ReadLine reads up to the next CR or LF (a CRLF pair counts as one) and
+// returns the line without its terminator
+func (k *Keyboard) ReadLine(done <-chan struct{}) (string, error) {
+	var line []byte
+	for {
+		c, err := k.Next(done)
+		if err != nil {
+			return string(line), err
+		}
+		i := bytes.IndexAny(c, "\r\n")
+		if i < 0 {
+			line = append(line, c...)
+			continue
+		}
+		line = append(line, c[:i]...)
+		rest := c[i+1:]
+		if c[i] == '\r' && len(rest) > 0 && rest[0] == '\n' {
+			rest = rest[1:]
+		}
+		k.Unread(rest)
+		return string(line), nil
+	}
+}
+
+// KeySet is a set of single-byte keys
+type KeySet [256]bool
+
+// parseKeyList parses a comma-separated list of keys such as
+// "ctrl-c,ctrl-],esc,0x7f" into a KeySet
+func parseKeyList(s string) (KeySet, error) {
+	var set KeySet
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		b, err := parseKey(name)
+		if err != nil {
+			return set, err
+		}
+		set[b] = true
+	}
+	return set, nil
+}
+
+// parseKey converts one key name into the byte the terminal sends for it
+func parseKey(name string) (byte, error) {
+	switch name {
+	case "esc":
+		return 0x1b, nil
+	case "tab":
+		return '\t', nil
+	case "enter":
+		return '\r', nil
+	case "backspace", "del":
+		return 0x7f, nil
+	}
+	if rest, ok := strings.CutPrefix(name, "ctrl-"); ok && len(rest) == 1 {
+		c := strings.ToUpper(rest)[0]
+		if c == '?' {
+			return 0x7f, nil
+		}
+		if c >= '@' && c <= '_' {
+			return c - '@', nil
+		}
+	}
+	if rest, ok := strings.CutPrefix(name, "0x"); ok {
+		if v, err := strconv.ParseUint(rest, 16, 8); err == nil {
+			return byte(v), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown key %q (use ctrl-<x>, esc, tab, enter, backspace or 0xNN)", name)
+}
+
+// index returns the position of the first byte of b in the set, or -1
+func (ks *KeySet) index(b []byte) int {
+	for i, c := range b {
+		if ks[c] {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseEscapeChar parses -e: "none", caret notation such as "^]", a key
+// name as in -block-keys, or a single literal character
+func parseEscapeChar(v string) (int, error) {
+	switch {
+	case strings.EqualFold(v, "none"):
+		return NoEscape, nil
+	case len(v) == 1:
+		return int(v[0]), nil
+	case len(v) == 2 && v[0] == '^':
+		c := strings.ToUpper(v[1:])[0]
+		if c == '?' {
+			return 0x7f, nil
+		}
+		if c >= '@' && c <= '_' {
+			return int(c - '@'), nil
+		}
+	}
+	b, err := parseKey(strings.ToLower(v))
+	if err != nil {
+		return 0, fmt.Errorf("invalid escape character %q (use ^X, ctrl-x, 0xNN or none)", v)
+	}
+	return int(b), nil
+}
+
+// caretName shows a byte the way telnet does, e.g. ^] for 0x1D
+func caretName(c byte) string {
+	switch {
+	case c < 0x20:
+		return "^" + string(rune(c+'@'))
+	case c == 0x7f:
+		return "^?"
+	case c < 0x7f:
+		return string(rune(c))
+	}
+	return fmt.Sprintf("0x%02x", c)
+}
+
+// filter removes every byte in the set from b, reusing its storage
+func (ks *KeySet) filter(b []byte) []byte {
+	out := b[:0]
+	for _, c := range b {
+		if !ks[c] {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// feedKeys turns the contents of a -feed file into keystrokes. Bytes are
+// sent verbatim (escape sequences and binary included), except that line
+// endings become CR, which is what pressing Enter sends.
+func feedKeys(data []byte) []byte {
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\r"))
+	return bytes.ReplaceAll(data, []byte("\n"), []byte("\r"))
+}
@@ -1,135 +1,755 @@
 package main
 
 import (
-	"bufio"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"net/url"
 	"os"
 	"os/signal"
+	"runtime"
+	"strings"
 	"syscall"
 	"time"
 
+	"better-telnet/telnet"
 	"golang.org/x/term"
 )
 
-// Telnet Protocol Constants
-const (
-	IAC  = 255 // Interpret As Command
-	DONT = 254
-	DO   = 253
-	WONT = 252
-	WILL = 251
-	SB   = 250 // Subnegotiation Begin
-	SE   = 240 // Subnegotiation End
-)
+// DefaultMaxNegotiations is how many DO/DONT/WILL/WONT commands a server may
+// send before we treat it as a negotiation flood and hang up
+const DefaultMaxNegotiations = 1000
 
 // ANSI Escape Sequences for terminal control
 const (
 	AnsiClearScreen = "\033[H\033[2J" // Move cursor home and clear screen
 	AnsiSetTitle    = "\033]0;%s\007" // Set window/tab title
+	AnsiPushTitle   = "\033[22;0t"    // Save the current title (xterm title stack)
+	AnsiPopTitle    = "\033[23;0t"    // Restore the saved title
+
+	// AnsiSoftReset is DECSTR plus the modes it leaves alone: mouse
+	// reporting, bracketed paste and the alternate screen
+	AnsiSoftReset = "\033[!p\033[?1000l\033[?1002l\033[?1003l\033[?1006l\033[?2004l\033[?1049l"
 )
 
 // Config holds the runtime configuration
 type Config struct {
-	Host    string
-	Port    string
-	LogFile string
+	Host     string
+	Port     string
+	LogFile  string
+	ReadMode telnet.ReadMode
+	// MaxNegotiations caps option commands accepted per session (0 = unlimited)
+	MaxNegotiations int
+	OnOption        []OptionHook
+	Capture         bool
+	CaptureMax      int
+	SSHTunnel       string // ProxyCommand-style command used instead of TCP
+	Require         []byte // Options that must be active after RequireWait
+	RequireWait     time.Duration
+	ZmodemDir       string // Receive Zmodem uploads into this directory
+	BlockKeys       KeySet // Keys swallowed before forwarding or local handling
+	SetTitle        bool
+	Encoding        string // Default charset, also offered first in CHARSET negotiation
+	Feed            []byte // Keystrokes injected before the keyboard takes over
+	CharDelay       time.Duration
+	Quiet           bool   // Suppress our own banners; stdout carries server data only
+	ClosedMessage   string // Shown when the server closes the connection ("" = none)
+	DumpOptions     string // Write the final option state here as JSON
+	SlowPrint       int    // Meter screen output to this many chars/sec (0 = off)
+	NotifyConnect   bool   // Alert once the connection is established
+	NotifyCmd       string // Run this instead of ringing the bell
+	ResizeDebounce  time.Duration
+	HealthCheck     time.Duration // Probe after this much silence (0 = off)
+	HealthTimeout   time.Duration
+	HealthProbe     string        // "timing-mark" or "ayt"
+	Keepalive       time.Duration // Send IAC NOP this often (0 = off)
+	Reconnect       bool          // Dial again when the connection is lost
+	XlateIn         *XlateTable   // Applied to server output; nil = none
+	XlateOut        *XlateTable   // Applied to typed input; nil = none
+	HistoryFile     string        // Persist command-mode history here
+	HistorySize     int
+	TLS             bool
+	TLSKnownHosts   string // Pinned certificate fingerprints
+	TLSAcceptNew    bool   // Re-pin a changed certificate instead of refusing
+	TLSSkipVerify   bool   // Accept any server certificate
+	TLSCA           string // Verify the server against this CA bundle instead of pinning
+	TLSCert         string // Client certificate (PEM)
+	TLSKey          string // Client key (PEM); defaults to TLSCert
+	Proxy           *url.URL
+	TranscriptHTML  string // Render the capture buffer to this HTML file at exit
+	Script          *Script
+	Batch           bool   // Run the script with no keyboard or raw mode; exit when it ends
+	ChooseAddress   bool   // Offer the other resolved addresses if one fails
+	NAWSCompat      bool   // Clamp reported window sizes, never sending 255
+	InterruptSeq    []byte // Sent for Ctrl+C; nil = plain ETX
+	LineNumbers     string // "", "display", "log" or "both"
+	MaxSB           int    // Cap on a single subnegotiation payload
+	HandlePager     bool   // Answer --More-- style prompts automatically
+	PagerKey        []byte
+	PagerPatterns   []string
+	PagerStrip      bool
+	ResetOnConnect  bool   // Soft-reset the local terminal before the session
+	StripBOM        bool   // Drop a UTF-8 BOM at the start of the server's output
+	SendBOM         bool   // Send a UTF-8 BOM before anything else
+	DetectEOL       bool   // Report the server's line ending style
+	DisplayCRLF     bool   // Show bare LF as CRLF on screen only
+	Escape          int    // Byte that opens command mode, or NoEscape
+	LogMode         string // "raw" (byte-exact) or "clean" (plain text)
+	Record          string // asciicast v2 file recording both directions
 }
 
+// stringList is a repeatable string flag
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ",") }
+func (l *stringList) Set(v string) error { *l = append(*l, v); return nil }
+
+// Process exit codes
+const (
+	ExitOK                = 0
+	ExitError             = 1
+	ExitRequirementFailed = 3 // A -require option was not negotiated
+	ExitScriptFailed      = 4 // A -script fail step ran
+	ExitScriptTimeout     = 5 // A -script expect step saw nothing it wanted
+	ExitSendFailed        = 6 // Writing to the server failed
+)
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(runReplay(os.Args[2:]))
+	}
+	os.Exit(run())
+}
+
+// run drives a whole session and returns the process exit code. It is split
+// from main so deferred cleanup (terminal restore, log close) always runs.
+func run() int {
 	// 1. Parse command-line arguments
 	config := parseArgs()
 
 	// 2. Connect to the target server
 	target := net.JoinHostPort(config.Host, config.Port)
-	fmt.Printf("[*] Connecting to %s...\r\n", target)
+	if !config.Quiet {
+		if config.SSHTunnel != "" {
+			fmt.Printf("[*] %s\r\n", tr("Connecting to %s via tunnel command...", target))
+		} else if config.Proxy != nil {
+			fmt.Printf("[*] %s\r\n", tr("Connecting to %s via proxy %s...", target, config.Proxy.Redacted()))
+		} else {
+			fmt.Printf("[*] %s\r\n", tr("Connecting to %s...", target))
+		}
+	}
 
-	// Set a connection timeout
-	conn, err := net.DialTimeout("tcp", target, 5*time.Second)
+	history, err := loadHistory(config.HistoryFile, config.HistorySize)
 	if err != nil {
-		log.Fatalf("[-] Connection failed: %v", err)
+		log.Fatalf("[-] %s", tr("Failed to read history file: %v", err))
 	}
-	defer conn.Close()
 
-	// 3. Set the local terminal to Raw Mode
-	fd := int(os.Stdin.Fd())
-	oldState, err := term.MakeRaw(fd)
+	conn, err := dialTarget(config, term.IsTerminal(int(os.Stdin.Fd())))
 	if err != nil {
-		log.Fatalf("[-] Failed to set raw mode: %v", err)
+		log.Fatalf("[-] %s", tr("Connection failed: %v", err))
+	}
+	connectedAt := time.Now()
+	if config.NotifyConnect {
+		notify(config, "connected")
 	}
-	// Ensure terminal state is restored on exit
-	defer term.Restore(fd, oldState)
 
-	// 4. IMPROVEMENT: Initialize terminal view (Clear screen & Set Title)
-	// We do this AFTER setting Raw Mode to ensure full control over output
-	setupTerminalOutput(config.Host, config.Port)
+	// 3. Set the local terminal to Raw Mode. A batch script doesn't touch
+	// the terminal at all, so its output can go straight to a CI log.
+	fd := int(os.Stdin.Fd())
+	var oldState *term.State
+	if !config.Batch {
+		oldState, err = term.MakeRaw(fd)
+		if err != nil {
+			log.Fatalf("[-] %s", tr("Failed to set raw mode: %v", err))
+		}
+		// Ensure terminal state is restored on exit
+		defer term.Restore(fd, oldState)
+		defer restoreTitle()
+
+		// 4. IMPROVEMENT: Initialize terminal view (Clear screen & Set Title)
+		// We do this AFTER setting Raw Mode to ensure full control over output
+		setupTerminalOutput(config)
+	}
 
-	// 5. Prepare output stream (Support optional logging)
-	var outputWriter io.Writer = os.Stdout
+	// 5. Prepare output stream (Support optional logging and capture)
+	var screen io.Writer = os.Stdout
+	var slow *slowWriter
+	if config.SlowPrint > 0 {
+		slow = newSlowWriter(os.Stdout, config.SlowPrint)
+		screen = slow
+	}
+	var recorder *Recorder
+	if config.Record != "" {
+		recorder, err = newRecorder(config.Record, net.JoinHostPort(config.Host, config.Port))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[-] %s\r\n", tr("Failed to start recording: %v", err))
+		} else {
+			defer func() {
+				if err := recorder.Close(); err != nil {
+					fmt.Fprintf(os.Stderr, "[-] %s\r\n", tr("Recording incomplete: %v", err))
+				}
+			}()
+			// Below any CRLF fixing, so playback looks just like the screen did
+			screen = io.MultiWriter(screen, recorder.Output())
+		}
+	}
+	outputWriter := screen
+	var logTap io.Writer
 	if config.LogFile != "" {
 		f, err := os.OpenFile(config.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "[-] Failed to open log file: %v\r\n", err)
+			fmt.Fprintf(os.Stderr, "[-] %s\r\n", tr("Failed to open log file: %v", err))
 		} else {
 			defer f.Close()
-			outputWriter = io.MultiWriter(os.Stdout, f)
+			logTap = f
+			if config.LogMode == "clean" {
+				logTap = newCleanLogWriter(f)
+			}
 			// Print a session start marker to the log/screen
-			fmt.Fprintf(outputWriter, "--- Session Start: %s ---\r\n", time.Now().Format(time.RFC3339))
+			fmt.Fprintf(io.MultiWriter(screen, logTap), "--- Session Start: %s ---\r\n", time.Now().Format(time.RFC3339))
 		}
 	}
+	if config.LineNumbers == "display" || config.LineNumbers == "both" {
+		outputWriter = newLineNumberWriter(outputWriter)
+	}
+	if logTap != nil && (config.LineNumbers == "log" || config.LineNumbers == "both") {
+		logTap = newLineNumberWriter(logTap)
+	}
+	var logging *logSwitch
+	if logTap != nil {
+		logging = &logSwitch{w: logTap}
+		logTap = logging
+	}
+	var capture *Capture
+	if config.Capture || config.TranscriptHTML != "" {
+		capture = NewCapture(config.CaptureMax)
+		outputWriter = io.MultiWriter(outputWriter, capture)
+	}
+	var expect *expectBuffer
+	if config.Script != nil {
+		expect = newExpectBuffer()
+		outputWriter = io.MultiWriter(outputWriter, expect)
+	}
 
 	// 6. Handle system signals
-	handleSignals(conn)
+	live := &liveConn{}
+	live.set(conn)
+	handleSignals(live)
+
+	// Optional application-level liveness probing, which notes every byte
+	// received, negotiation included
+	var health *healthCheck
+	if config.HealthCheck > 0 {
+		health, err = newHealthCheck(config.HealthCheck, config.HealthTimeout, config.HealthProbe)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[-] %v\r\n", err)
+			return ExitError
+		}
+	}
 
-	// 7. Start full-duplex communication channels
-	errChan := make(chan error, 1)
+	// Option events and the hooks that watch them
+	events := &EventBus{}
+	installOptionHooks(events, config.OnOption, config)
+	if !config.Quiet {
+		events.Subscribe(func(e Event) {
+			if e.Kind == EventProtocolError {
+				fmt.Fprintf(os.Stderr, "\r\n[-] %s\r\n", tr("Protocol error (%s): %s", e.Option, e.Detail))
+			}
+		})
+	}
+	var eol *eolDetector
+	if config.DetectEOL {
+		eol = &eolDetector{}
+	}
+
+	// 7. Run the session, and with -reconnect keep dialing it back up. The
+	// terminal, log, recording and capture all carry on across reconnects.
+	keyboard := NewKeyboard(os.Stdin)
+	session := &Session{
+		config:   config,
+		fd:       fd,
+		oldState: oldState,
+		keyboard: keyboard,
+		capture:  capture,
+		history:  history,
+		eol:      eol,
+		logging:  logging,
+		recorder: recorder,
+		done:     make(chan struct{}),
+	}
+	streams := &sessionIO{display: outputWriter, log: logTap, expect: expect, events: events, health: health}
+	var delay backoff
+	for {
+		err = session.serve(conn, connectedAt, streams)
+		if live.wasInterrupted() {
+			err = errInterrupted
+		}
+		if !config.Reconnect || !reconnectable(err) {
+			break
+		}
+		reason := tr("closed by the server")
+		if err != nil {
+			reason = err.Error()
+		}
+		fmt.Fprintf(screen, "\r\n[-] %s\r\n", tr("Connection lost (%s).", reason))
+		// A connection that held up for a while starts the backoff over
+		if time.Since(connectedAt) >= reconnectMaxDelay {
+			delay.reset()
+		}
+		conn, err = session.redial(screen, &delay)
+		if err != nil {
+			break
+		}
+		live.set(conn)
+		connectedAt = time.Now()
+		session = session.renew()
+		var title io.Writer
+		if config.SetTitle && !config.Batch && titleSupported() {
+			title = os.Stdout
+		}
+		announceRestored(config, screen, logTap, title, connectedAt)
+	}
+	options, charset := session.options, session.charset
+
+	if slow != nil {
+		slow.Flush()
+	}
+
+	code := ExitOK
+	var sendErr *telnet.WriteError
+	if errors.As(err, &sendErr) {
+		fmt.Printf("\r\n[-] %s\r\n", tr("Failed to send to server: %v", sendErr.Err))
+		code = ExitSendFailed
+	} else if errors.Is(err, telnet.ErrNegotiationFlood) {
+		fmt.Printf("\r\n[-] %s\r\n", tr("Disconnecting: %v", err))
+		code = ExitError
+	} else if errors.Is(err, ErrRequirementFailed) {
+		fmt.Printf("\r\n[-] %v\r\n", err)
+		code = ExitRequirementFailed
+	} else if errors.Is(err, ErrScriptFailed) {
+		fmt.Printf("\r\n[-] %v\r\n", err)
+		code = ExitScriptFailed
+	} else if errors.Is(err, ErrScriptTimeout) {
+		fmt.Printf("\r\n[-] %v\r\n", err)
+		code = ExitScriptTimeout
+	} else if errors.Is(err, ErrPeerDead) {
+		fmt.Printf("\r\n[-] %s\r\n", tr("No reply to the health check within %s, connection presumed dead.", config.HealthTimeout))
+		code = ExitError
+	} else if errors.Is(err, errReconnectRefused) {
+		// redial has said why
+		code = ExitError
+	} else if errors.Is(err, ErrTunnelExited) {
+		fmt.Printf("\r\n[-] %v\r\n", err)
+		code = ExitError
+	} else if errors.Is(err, errUserQuit) || errors.Is(err, errScriptQuit) || errors.Is(err, errReconnectAborted) ||
+		errors.Is(err, errInputClosed) || errors.Is(err, errInterrupted) {
+		// We closed it ourselves, so "by foreign host" would be misleading
+		if !config.Quiet {
+			fmt.Printf("\r\n[*] %s\r\n", tr("Connection closed."))
+		}
+	} else if config.ClosedMessage != "" {
+		var out io.Writer = os.Stdout
+		if config.Quiet {
+			out = os.Stderr
+		}
+		fmt.Fprintf(out, "\r\n[*] %s\r\n", config.ClosedMessage)
+	}
+
+	if eol != nil {
+		eol.finish()
+	}
+
+	if err := history.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "[-] %s\r\n", tr("Failed to save history: %v", err))
+	}
+
+	if config.DumpOptions != "" {
+		if err := options.writeDump(config.DumpOptions, config, charset); err != nil {
+			fmt.Fprintf(os.Stderr, "[-] %s\r\n", tr("Failed to write option dump: %v", err))
+		}
+	}
+
+	if config.TranscriptHTML != "" {
+		if err := writeTranscript(config.TranscriptHTML, capture, config); err != nil {
+			fmt.Fprintf(os.Stderr, "[-] %s\r\n", tr("Failed to write transcript: %v", err))
+		} else if !config.Quiet {
+			fmt.Printf("[+] %s\r\n", tr("Transcript written to %s", config.TranscriptHTML))
+		}
+	}
+
+	if config.Capture && !config.Batch {
+		term.Restore(fd, oldState)
+		capture.promptSave(keyboard)
+	}
+	return code
+}
+
+// sessionIO is what a connection plugs into that outlives it
+type sessionIO struct {
+	display io.Writer     // Screen, with the capture and expect taps
+	log     io.Writer     // nil unless -log is set
+	expect  *expectBuffer // nil unless -script is set
+	events  *EventBus
+	health  *healthCheck // nil unless -health-check is set
+}
+
+// output builds the writer the server's bytes are copied into, with the
+// pager's key going to reply. The log and -detect-eol tap the stream ahead
+// of translation, decoding and display normalization, so they see exactly
+// what the server sent; only the screen gets the rest.
+func (s *Session) output(streams *sessionIO, reply io.Writer) io.Writer {
+	config := s.config
+	w := streams.display
+	if config.DisplayCRLF {
+		w = &crlfWriter{w: w}
+	}
+	if config.HandlePager {
+		w = newPagerWriter(w, reply, config.PagerKey, config.PagerPatterns, config.PagerStrip)
+	}
+	if config.StripBOM {
+		w = &bomWriter{w: w}
+	}
+	w = &decodeWriter{w: w, charset: s.charset}
+	if config.XlateIn != nil {
+		w = &xlateWriter{w: w, table: config.XlateIn}
+	}
+	if streams.log != nil {
+		w = io.MultiWriter(streams.log, w)
+	}
+	if s.eol != nil {
+		w = io.MultiWriter(s.eol, w)
+	}
+	return w
+}
+
+// serve runs one connection to the server: it negotiates options, wires the
+// output filters and starts the network, keyboard and script goroutines,
+// then waits for the first of them to end. The connection is closed and
+// every goroutine that reads it has finished by the time serve returns.
+func (s *Session) serve(conn net.Conn, connectedAt time.Time, streams *sessionIO) error {
+	config := s.config
+	var netConn net.Conn = conn
+	if streams.health != nil {
+		streams.health.touch()
+		netConn = &activityConn{Conn: netConn, h: streams.health}
+	}
+	if config.Keepalive > 0 {
+		tcpKeepAlive(conn, config.Keepalive)
+	}
+
+	// Option state for this connection
+	s.options = NewOptionTable(streams.events, connectedAt)
+	// A -require option must not be refused when the server offers it
+	s.options.accept(config.Require)
+	s.options.accept(hookOptions(config.OnOption))
+	if config.ZmodemDir != "" {
+		// Zmodem needs an 8-bit clean link
+		s.options.accept([]byte{telnet.OptBinary})
+	}
+
+	// Every goroutine that talks to the server goes through tconn, which
+	// serializes its writes
+	tconn := telnet.NewConn(netConn, s.options.Options)
+	tconn.Mode = config.ReadMode
+	tconn.MaxNegotiations = config.MaxNegotiations
+	tconn.MaxSB = config.MaxSB
+	s.conn = tconn
+
+	s.charset = NewCharset(config.Encoding, streams.events)
+	s.options.Register(telnet.OptCharset, s.charset, true, true)
+	naws := NewNAWS(terminalFd(), s.options, tconn.Raw(), config.NAWSCompat)
+	s.options.Register(telnet.OptNAWS, naws, true, false)
+	s.options.Register(telnet.OptTermType, NewTerminalType(s.options), true, false)
+	outputWriter := s.output(streams, tconn)
+
+	// Start full-duplex communication channels. One slot per sender, so
+	// none of them blocks once we stop listening.
+	errChan := make(chan error, 6)
+	defer tconn.Close()
+
+	// Like BSD telnet, only offer options unprompted on the telnet port.
+	// Anything else may be a raw TCP service that would print the bytes.
+	if config.Port == "23" {
+		offer := append(s.options.OfferLocal(telnet.OptTermType), s.options.OfferLocal(telnet.OptNAWS)...)
+		if config.ZmodemDir != "" {
+			offer = append(offer, s.options.RequestRemote(telnet.OptBinary, true)...)
+			offer = append(offer, s.options.OfferLocal(telnet.OptBinary)...)
+		}
+		if _, err := tconn.WriteRaw(offer); err != nil {
+			return err
+		}
+	}
+
+	// The BOM goes ahead of anything the keyboard, -feed or a script sends
+	if config.SendBOM {
+		if err := s.write([]byte(utf8BOM)); err != nil {
+			return err
+		}
+	}
 
 	// Goroutine A: Network -> Screen/File
+	readDone := make(chan struct{})
 	go func() {
-		telnetReader := NewTelnetReader(conn)
-		_, err := io.Copy(outputWriter, telnetReader)
+		defer close(readDone)
+		if config.ZmodemDir != "" {
+			errChan <- NewZmodemReceiver(config.ZmodemDir, tconn).Pump(tconn, outputWriter)
+			return
+		}
+		_, err := io.Copy(outputWriter, tconn)
 		errChan <- err
 	}()
 
-	// Goroutine B: Keyboard -> Network
-	go func() {
-		_, err := io.Copy(conn, os.Stdin)
-		errChan <- err
-	}()
+	go naws.watch(config.ResizeDebounce, s.done)
+	if streams.health != nil {
+		go streams.health.run(tconn.Raw(), errChan, s.done)
+	}
+	if config.Keepalive > 0 {
+		go keepalive(tconn.Raw(), config.Keepalive, errChan, s.done)
+	}
+
+	scriptDone := make(chan struct{})
+	if config.Script != nil {
+		go func() {
+			err := config.Script.run(s, streams.expect)
+			close(scriptDone)
+			if err == nil && config.Batch {
+				// Nobody is at the keyboard to carry on
+				err = errScriptQuit
+			}
+			if err != nil && err != errSessionDone {
+				errChan <- err
+			}
+		}()
+	}
 
-	// Wait for exit
-	<-errChan
-	fmt.Printf("\r\n[*] Connection closed by foreign host.\r\n")
+	// Goroutine B: Keyboard -> Network (with escape to command mode)
+	kbDone := make(chan struct{})
+	if config.Batch {
+		close(kbDone)
+	} else {
+		go func() {
+			err := s.pumpKeyboard()
+			if err == io.EOF {
+				err = errInputClosed
+			}
+			errChan <- err
+			close(kbDone)
+		}()
+	}
+
+	// Abort if required options aren't active once negotiation has settled
+	if len(config.Require) > 0 {
+		go func() {
+			select {
+			case <-time.After(config.RequireWait):
+			case <-s.done:
+				return
+			}
+			if missing := s.options.Missing(config.Require); len(missing) > 0 {
+				errChan <- fmt.Errorf("%w: %s", ErrRequirementFailed, strings.Join(missing, ", "))
+			}
+		}()
+	}
+
+	// Wait for exit, then let the keyboard pump wind down before touching
+	// stdin, and the reader before the output goes to another connection
+	err := <-errChan
+	if config.Batch && !config.Reconnect && reconnectable(err) {
+		select {
+		case <-scriptDone:
+		default:
+			// Nobody will see the rest of the script run, so this is a failure
+			if err == nil {
+				err = fmt.Errorf("%w: connection closed before the script finished", ErrScriptFailed)
+			} else {
+				err = fmt.Errorf("%w: connection lost before the script finished: %v", ErrScriptFailed, err)
+			}
+		}
+	}
+	close(s.done)
+	<-kbDone
+	tconn.Close()
+	<-readDone
+	return err
 }
 
 // setupTerminalOutput handles visual improvements like clearing screen and setting tab title
-func setupTerminalOutput(host, port string) {
+func setupTerminalOutput(config Config) {
+	host, port := config.Host, config.Port
+	if config.ResetOnConnect {
+		// Undo whatever the previous program left behind
+		fmt.Print(AnsiSoftReset + AnsiClearScreen)
+	}
+	if config.Quiet {
+		// Leave the screen alone; only the server's output should appear
+		if config.SetTitle {
+			setTitle(host, port)
+		}
+		return
+	}
+
 	// 1. Clear the screen so output starts from the top
 	fmt.Print(AnsiClearScreen)
 
-	// 2. Set the Windows Terminal Tab Title to "Telnet host:port"
-	title := fmt.Sprintf("Telnet %s:%s", host, port)
-	fmt.Printf(AnsiSetTitle, title)
+	// 2. Optionally set the tab title to "bettertelnet: host:port"
+	if config.SetTitle {
+		setTitle(host, port)
+	}
 
 	// 3. Print a friendly banner at the very top
-	fmt.Printf("Connected to %s:%s\r\n", host, port)
-	fmt.Printf("Use Ctrl+C to exit.\r\n")
+	fmt.Printf("%s\r\n", tr("Connected to %s", host+":"+port))
+	switch {
+	case config.Escape == NoEscape:
+		fmt.Printf("%s\r\n", tr("No escape character. Close the terminal to exit."))
+	case config.BlockKeys[config.Escape]:
+		fmt.Printf("%s\r\n", tr("Escape character is blocked. Close the terminal to exit."))
+	default:
+		fmt.Printf("%s\r\n", tr("Escape character is '%s'.", caretName(byte(config.Escape))))
+	}
 	fmt.Printf("----------------------------------------------------------------\r\n")
 }
 
+// announceRestored marks a reconnect: a banner on screen and in the log,
+// the title pointed at the new connection when title is set, and the
+// connect notification
+func announceRestored(config Config, screen, logTap, title io.Writer, at time.Time) {
+	target := net.JoinHostPort(config.Host, config.Port)
+	fmt.Fprintf(screen, "[+] %s\r\n", tr("Reconnected to %s, session restored.", target))
+	if logTap != nil {
+		fmt.Fprintf(logTap, "--- Session Restored: %s ---\r\n", at.Format(time.RFC3339))
+	}
+	if title != nil {
+		writeTitle(title, config.Host, config.Port)
+	}
+	if config.NotifyConnect {
+		notify(config, "connected")
+	}
+}
+
+// titleSupported reports whether stdout is a terminal that understands OSC titles
+func titleSupported() bool {
+	return term.IsTerminal(int(os.Stdout.Fd())) && os.Getenv("TERM") != "dumb"
+}
+
+// titleSaved records that the original title was pushed and must be restored
+var titleSaved bool
+
+// setTitle points the window/tab title at the current target, if the
+// terminal supports titles
+func setTitle(host, port string) {
+	if titleSupported() {
+		writeTitle(os.Stdout, host, port)
+	}
+}
+
+// writeTitle sends the title sequence to w. The first call saves the
+// original title so restoreTitle can put it back, and later calls (e.g.
+// after a reconnect) just retarget it.
+func writeTitle(w io.Writer, host, port string) {
+	if !titleSaved {
+		fmt.Fprint(w, AnsiPushTitle)
+		titleSaved = true
+	}
+	fmt.Fprintf(w, AnsiSetTitle, "bettertelnet: "+net.JoinHostPort(host, port))
+}
+
+// restoreTitle undoes setTitle. Windows Terminal has no title stack, but
+// an empty title makes it fall back to the profile's default.
+func restoreTitle() {
+	if !titleSaved {
+		return
+	}
+	if runtime.GOOS == "windows" {
+		fmt.Printf(AnsiSetTitle, "")
+	} else {
+		fmt.Print(AnsiPopTitle)
+	}
+	titleSaved = false
+}
+
+// defaultClosedMessage is shown when the server hangs up, unless overridden
+const defaultClosedMessage = "Connection closed by foreign host."
+
 // parseArgs parses arguments
 func parseArgs() Config {
 	logFile := flag.String("log", "", "Log output to file (optional)")
+	readMode := flag.String("read-mode", "burst", "When to flush received data: line, burst or char")
+	capture := flag.Bool("capture", false, "Keep the session output in memory so it can be saved later")
+	captureMax := flag.String("capture-max", "16M", "Maximum size of the -capture buffer (K, M, G suffixes allowed)")
+	sshTunnel := flag.String("ssh-tunnel", "", "Connect through a command's stdin/stdout, e.g. \"ssh user@bastion -W %h:%p\"")
+	require := flag.String("require", "", "Comma-separated options that must be negotiated, e.g. NAWS,BINARY (exit code 3 if not)")
+	requireWait := flag.Duration("require-wait", 3*time.Second, "How long negotiation may settle before -require is checked")
+	zmodemDir := flag.String("zmodem-dir", "", "Receive Zmodem (sz) transfers from the server into this directory")
+	blockKeys := flag.String("block-keys", "", "Comma-separated keys never sent or acted on, e.g. ctrl-c,ctrl-z,ctrl-] (blocking ctrl-] disables command mode)")
+	setTitleFlag := flag.Bool("set-title", false, "Set the terminal tab title to the connection while the session runs")
+	encoding := flag.String("encoding", "utf-8", "Character set of the server: utf-8, latin1 or ascii (CHARSET negotiation may override it)")
+	feedFile := flag.String("feed", "", "Type the contents of this file into the session before handing over to the keyboard")
+	charDelay := flag.Duration("char-delay", 0, "Pause between characters sent to the server, e.g. 20ms")
+	quiet := flag.Bool("quiet", false, "Suppress banners and status messages (the closed message goes to stderr)")
+	closedMessage := flag.String("closed-message", defaultClosedMessage, "Message shown when the server closes the connection (empty to disable)")
+	dumpOptions := flag.String("dump-options", "", "Write the negotiated option state to this JSON file when the session ends")
+	slowPrint := flag.Int("slowprint", 0, "Print server output at this many characters per second (0 = as fast as it arrives)")
+	notifyConnect := flag.Bool("notify-connect", false, "Ring the bell (or run -notify-cmd) when the connection is established")
+	notifyCmd := flag.String("notify-cmd", "", "Command to run for notifications instead of ringing the bell")
+	resizeDebounce := flag.Duration("resize-debounce", DefaultResizeDebounce, "Wait for the window size to settle this long before sending NAWS")
+	healthCheck := flag.Duration("health-check", 0, "Probe the server after this much silence and hang up if it doesn't answer, e.g. 60s")
+	healthTimeout := flag.Duration("health-timeout", 10*time.Second, "How long to wait for a reply to a health-check probe")
+	healthProbe := flag.String("health-probe", "timing-mark", "Health-check probe: timing-mark (IAC DO TIMING-MARK) or ayt (IAC AYT)")
+	keepaliveFlag := flag.Duration("keepalive", 0, "Send IAC NOP and TCP keepalives this often to detect a dead peer, e.g. 30s")
+	reconnect := flag.Bool("reconnect", false, "When the connection is lost, dial again with backoff and carry on the session")
+	xlateIn := flag.String("xlate-in", "", "Translate bytes received from the server using this table file")
+	xlateOut := flag.String("xlate-out", "", "Translate typed bytes sent to the server using this table file")
+	historyFile := flag.String("history-file", "", "Load and save the history of sent lines (for !! and !N) in this file")
+	historySize := flag.Int("history-size", DefaultHistorySize, "Number of sent lines to remember")
+	useTLS := flag.Bool("tls", false, "Connect over TLS (telnets), pinning the server certificate on first use")
+	tlsSkipVerify := flag.Bool("tls-skip-verify", false, "Accept any TLS server certificate (implies -tls)")
+	tlsCA := flag.String("tls-ca", "", "Verify the TLS server against the CA certificates in this PEM file instead of pinning (implies -tls)")
+	tlsCert := flag.String("tls-cert", "", "Client certificate to present, PEM (implies -tls)")
+	tlsKey := flag.String("tls-key", "", "Private key for -tls-cert, PEM (default: read from the -tls-cert file)")
+	proxyFlag := flag.String("proxy", "", "Connect through a proxy: socks5://[user:pass@]host:port or http://[user:pass@]host:port")
+	tlsKnownHosts := flag.String("tls-known-hosts", defaultKnownHostsFile(), "File of pinned TLS certificate fingerprints")
+	tlsAcceptNew := flag.Bool("tls-accept-new", false, "Trust and re-pin a server certificate that no longer matches the pinned one")
+	transcriptHTML := flag.String("transcript-html", "", "Write the session output, with colors, to this HTML file on exit (cursor movement is not reproduced)")
+	scriptFile := flag.String("script", "", "Run expect/send steps from this file once connected")
+	expectTimeout := flag.Duration("expect-timeout", DefaultExpectTimeout, "Timeout for -script expect steps that don't give their own")
+	batch := flag.Bool("batch", false, "Run -script without the keyboard and exit when it ends (implied when stdin is not a terminal)")
+	chooseAddress := flag.Bool("choose-address", false, "If the host has several addresses and one fails, pick another from a menu (tried in order when not interactive)")
+	nawsCompat := flag.Bool("naws-compat", false, "Clamp reported window sizes to 20x5..254x254, never sending a 255 byte; for BusyBox telnetd and other embedded servers that read NAWS as a fixed 9-byte frame")
+	interruptSeq := flag.String("interrupt-seq", `\x03`, "What Ctrl+C sends: an escaped string such as \"\\x1bq\", or ip for IAC IP")
+	lineNumbers := flag.String("line-numbers", "", "Number output lines on the display, in the log, or both (display, log, both)")
+	maxSB := flag.String("max-sb", "64K", "Discard subnegotiations with a payload larger than this (K, M suffixes allowed; 0 = unlimited)")
+	handlePager := flag.Bool("handle-pager", false, "Answer pager prompts such as --More-- automatically")
+	pagerKey := flag.String("pager-key", " ", "Key sent to advance a pager prompt (escapes like \\r allowed)")
+	var pagerPatterns stringList
+	flag.Var(&pagerPatterns, "pager-pattern", "Pager prompt to answer, replacing the built-in list (repeatable)")
+	pagerStrip := flag.Bool("pager-strip", false, "Remove answered pager prompts from the output")
+	resetOnConnect := flag.Bool("reset-on-connect", false, "Soft-reset and clear the local terminal before showing the session")
+	stripBOM := flag.Bool("strip-bom", false, "Drop a UTF-8 byte order mark at the start of the server's output")
+	sendBOM := flag.Bool("send-bom", false, "Send a UTF-8 byte order mark to the server before any input")
+	displayCRLF := flag.Bool("display-crlf", false, "Show bare LF from the server as CRLF on screen; the log keeps the bytes as sent")
+	detectEOL := flag.Bool("detect-eol", false, "Report whether the server ends lines with LF, CRLF or CR, warning if it mixes them")
+	escapeFlag := flag.String("e", "^]", "Escape character that opens the telnet> prompt (^X, ctrl-x, 0xNN, or none)")
+	logMode := flag.String("logmode", "raw", "Log format: raw (bytes as received) or clean (no escape codes, timestamped lines)")
+	recordFile := flag.String("record", "", "Record the session, output and typed input with timing, to this asciicast v2 file (play it with \"replay\")")
+	langFlag := flag.String("lang", "", "Language for BetterTelnet's own messages: en or zh (default from $LANG)")
+	var onOption stringList
+	flag.Var(&onOption, "on-option", "Run a command when an option is negotiated, as OPTION:command (repeatable)")
+	maxNegotiations := flag.Int("max-negotiations", DefaultMaxNegotiations, "Disconnect after this many option negotiations from the server (0 = unlimited)")
 
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s [-log filename] <host> [port]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] <host> [port]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s replay [-speed N] [-max-idle D] <file.cast>\n", os.Args[0])
 		flag.PrintDefaults()
 	}
 
 	flag.Parse()
+	setLanguage(*langFlag)
+	if *closedMessage == defaultClosedMessage {
+		*closedMessage = tr(defaultClosedMessage)
+	}
 
 	args := flag.Args()
 	if len(args) < 1 {
@@ -144,83 +764,235 @@ func parseArgs() Config {
 		port = args[1]
 	}
 
+	mode, err := parseReadMode(*readMode)
+	if err != nil {
+		log.Fatalf("[-] %v", err)
+	}
+	if *maxNegotiations < 0 {
+		log.Fatalf("[-] -max-negotiations must not be negative")
+	}
+	maxCapture, err := parseSize(*captureMax)
+	if err != nil {
+		log.Fatalf("[-] -capture-max: %v", err)
+	}
+	var required []byte
+	if *require != "" {
+		for _, name := range strings.Split(*require, ",") {
+			opt, err := telnet.ParseOption(name)
+			if err != nil {
+				log.Fatalf("[-] -require: %v", err)
+			}
+			required = append(required, opt)
+		}
+	}
+	blocked, err := parseKeyList(*blockKeys)
+	if err != nil {
+		log.Fatalf("[-] -block-keys: %v", err)
+	}
+	defaultCharset, ok := canonicalCharset(*encoding)
+	if !ok {
+		log.Fatalf("[-] Unsupported -encoding %q (want utf-8, latin1 or ascii)", *encoding)
+	}
+	var feed []byte
+	if *feedFile != "" {
+		data, err := os.ReadFile(*feedFile)
+		if err != nil {
+			log.Fatalf("[-] %s", tr("Failed to read feed file: %v", err))
+		}
+		feed = feedKeys(data)
+	}
+	if *slowPrint < 0 {
+		log.Fatalf("[-] -slowprint must not be negative")
+	}
+	if *healthCheck < 0 {
+		log.Fatalf("[-] -health-check must not be negative")
+	}
+	if *healthTimeout <= 0 {
+		log.Fatalf("[-] -health-timeout must be positive")
+	}
+	if *keepaliveFlag < 0 {
+		log.Fatalf("[-] -keepalive must not be negative")
+	}
+	if *historySize < 1 {
+		log.Fatalf("[-] -history-size must be at least 1")
+	}
+	// 0 lifts the cap, as it does for Conn.MaxSB
+	var maxSBSize int
+	if strings.TrimSpace(*maxSB) != "0" {
+		maxSBSize, err = parseSize(*maxSB)
+		if err != nil {
+			log.Fatalf("[-] -max-sb: %v", err)
+		}
+	}
+	pagerKeyBytes, err := unescape(*pagerKey)
+	if err != nil || len(pagerKeyBytes) == 0 {
+		log.Fatalf("[-] Invalid -pager-key %q", *pagerKey)
+	}
+	if len(pagerPatterns) == 0 {
+		pagerPatterns = defaultPagerPatterns
+	}
+	for _, p := range pagerPatterns {
+		if p == "" {
+			log.Fatalf("[-] -pager-pattern must not be empty")
+		}
+	}
+	switch *lineNumbers {
+	case "", "display", "log", "both":
+	default:
+		log.Fatalf("[-] Invalid -line-numbers %q (want display, log or both)", *lineNumbers)
+	}
+	if *logMode != "raw" && *logMode != "clean" {
+		log.Fatalf("[-] Invalid -logmode %q (want raw or clean)", *logMode)
+	}
+	escape, err := parseEscapeChar(*escapeFlag)
+	if err != nil {
+		log.Fatalf("[-] -e: %v", err)
+	}
+	interrupt, err := parseInterruptSeq(*interruptSeq)
+	if err != nil {
+		log.Fatalf("[-] -interrupt-seq: %v", err)
+	}
+	var proxy *url.URL
+	if *proxyFlag != "" {
+		if proxy, err = parseProxy(*proxyFlag); err != nil {
+			log.Fatalf("[-] -proxy: %v", err)
+		}
+		if *sshTunnel != "" {
+			log.Fatalf("[-] -proxy and -ssh-tunnel cannot be combined")
+		}
+		if *chooseAddress {
+			// The proxy resolves the host, so there is no address list to choose from
+			log.Fatalf("[-] -proxy and -choose-address cannot be combined")
+		}
+	}
+	if *tlsSkipVerify && *tlsCA != "" {
+		log.Fatalf("[-] -tls-skip-verify and -tls-ca cannot be combined")
+	}
+	if *tlsKey != "" && *tlsCert == "" {
+		log.Fatalf("[-] -tls-key needs -tls-cert")
+	}
+	var xlate [2]*XlateTable
+	for i, path := range []string{*xlateIn, *xlateOut} {
+		if path == "" {
+			continue
+		}
+		if xlate[i], err = loadXlateTable(path); err != nil {
+			log.Fatalf("[-] %s", tr("Invalid translation table: %v", err))
+		}
+	}
+	var script *Script
+	if *scriptFile != "" {
+		if *expectTimeout <= 0 {
+			log.Fatalf("[-] -expect-timeout must be positive")
+		}
+		if script, err = loadScript(*scriptFile, *expectTimeout); err != nil {
+			log.Fatalf("[-] %s", tr("Invalid script: %v", err))
+		}
+		// Without a terminal there is nobody to hand the session over to
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			*batch = true
+		}
+	} else if *batch {
+		log.Fatalf("[-] -batch needs -script")
+	}
+	var hooks []OptionHook
+	for _, v := range onOption {
+		h, err := parseOptionHook(v)
+		if err != nil {
+			log.Fatalf("[-] %v", err)
+		}
+		hooks = append(hooks, h)
+	}
+
 	return Config{
-		Host:    host,
-		Port:    port,
-		LogFile: *logFile,
+		Host:     host,
+		Port:     port,
+		LogFile:  *logFile,
+		ReadMode: mode,
+
+		MaxNegotiations: *maxNegotiations,
+		OnOption:        hooks,
+		Capture:         *capture,
+		CaptureMax:      maxCapture,
+		SSHTunnel:       *sshTunnel,
+		Require:         required,
+		RequireWait:     *requireWait,
+		ZmodemDir:       *zmodemDir,
+		BlockKeys:       blocked,
+		SetTitle:        *setTitleFlag,
+		Encoding:        defaultCharset,
+		Feed:            feed,
+		CharDelay:       *charDelay,
+		Quiet:           *quiet,
+		ClosedMessage:   *closedMessage,
+		DumpOptions:     *dumpOptions,
+		SlowPrint:       *slowPrint,
+		NotifyConnect:   *notifyConnect,
+		NotifyCmd:       *notifyCmd,
+		ResizeDebounce:  *resizeDebounce,
+		HealthCheck:     *healthCheck,
+		HealthTimeout:   *healthTimeout,
+		HealthProbe:     *healthProbe,
+		Keepalive:       *keepaliveFlag,
+		Reconnect:       *reconnect,
+		XlateIn:         xlate[0],
+		XlateOut:        xlate[1],
+		HistoryFile:     *historyFile,
+		HistorySize:     *historySize,
+		TLS:             *useTLS || *tlsSkipVerify || *tlsCA != "" || *tlsCert != "",
+		TLSSkipVerify:   *tlsSkipVerify,
+		TLSCA:           *tlsCA,
+		TLSCert:         *tlsCert,
+		TLSKey:          *tlsKey,
+		Proxy:           proxy,
+		TLSKnownHosts:   *tlsKnownHosts,
+		TLSAcceptNew:    *tlsAcceptNew,
+		TranscriptHTML:  *transcriptHTML,
+		Script:          script,
+		Batch:           *batch,
+		ChooseAddress:   *chooseAddress,
+		NAWSCompat:      *nawsCompat,
+		InterruptSeq:    interrupt,
+		LineNumbers:     *lineNumbers,
+		MaxSB:           maxSBSize,
+		HandlePager:     *handlePager,
+		PagerKey:        pagerKeyBytes,
+		PagerPatterns:   pagerPatterns,
+		PagerStrip:      *pagerStrip,
+		ResetOnConnect:  *resetOnConnect,
+		StripBOM:        *stripBOM,
+		SendBOM:         *sendBOM,
+		DetectEOL:       *detectEOL,
+		DisplayCRLF:     *displayCRLF,
+		Escape:          escape,
+		LogMode:         *logMode,
+		Record:          *recordFile,
+	}
+}
+
+// parseReadMode converts the -read-mode flag value into a ReadMode
+func parseReadMode(s string) (telnet.ReadMode, error) {
+	switch s {
+	case "line":
+		return telnet.ReadLine, nil
+	case "burst":
+		return telnet.ReadBurst, nil
+	case "char":
+		return telnet.ReadChar, nil
 	}
+	return telnet.ReadBurst, fmt.Errorf("invalid read mode %q (want line, burst or char)", s)
 }
 
-// handleSignals captures Ctrl+C
-func handleSignals(conn net.Conn) {
+// handleSignals ends the session on Ctrl+C (which only raises SIGINT in
+// cooked mode) or SIGTERM. It hangs up rather than exiting, so run() still
+// saves history, closes the recording and restores the terminal. A second
+// signal takes the default action, in case that cleanup is stuck.
+func handleSignals(live *liveConn) {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-c
-		conn.Close()
-		os.Exit(0)
+		signal.Stop(c)
+		live.interrupt()
 	}()
 }
-
-// Telnet Protocol Handler
-type TelnetReader struct {
-	reader *bufio.Reader
-}
-
-func NewTelnetReader(r io.Reader) *TelnetReader {
-	return &TelnetReader{
-		reader: bufio.NewReader(r),
-	}
-}
-
-func (t *TelnetReader) Read(p []byte) (n int, err error) {
-	for n < len(p) {
-		b, err := t.reader.ReadByte()
-		if err != nil {
-			return n, err
-		}
-
-		if b == IAC {
-			cmd, err := t.reader.ReadByte()
-			if err != nil {
-				return n, err
-			}
-
-			if cmd == IAC {
-				p[n] = IAC
-				n++
-			} else if cmd == DO || cmd == DONT || cmd == WILL || cmd == WONT {
-				_, err = t.reader.ReadByte()
-				if err != nil {
-					return n, err
-				}
-			} else if cmd == SB {
-				for {
-					sbBytes, err := t.reader.ReadByte()
-					if err != nil {
-						return n, err
-					}
-					if sbBytes == IAC {
-						next, err := t.reader.ReadByte()
-						if err != nil {
-							return n, err
-						}
-						if next == SE {
-							break
-						}
-					}
-				}
-			} else {
-				continue
-			}
-		} else {
-			p[n] = b
-			n++
-		}
-
-		if t.reader.Buffered() == 0 && n > 0 {
-			break
-		}
-	}
-	return n, nil
-}
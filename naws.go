@@ -0,0 +1,121 @@
+package main
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"better-telnet/telnet"
+	"golang.org/x/term"
+)
+
+// DefaultResizeDebounce is how long the window size must stay put before a
+// resize is reported to the server
+const DefaultResizeDebounce = 100 * time.Millisecond
+
+// Bounds used by -naws-compat. The upper bound keeps both bytes of each
+// dimension below 255, so no IAC escaping is ever needed.
+const (
+	nawsCompatMinWidth  = 20
+	nawsCompatMinHeight = 5
+	nawsCompatMax       = 254
+)
+
+// NAWS reports our terminal size to the server (RFC 1073)
+type NAWS struct {
+	fd      int // Terminal to measure
+	options *OptionTable
+	w       io.Writer
+	compat  bool // Clamp sizes for servers with broken NAWS parsing
+
+	mu            sync.Mutex
+	width, height int // Last size sent
+}
+
+// NewNAWS measures fd and sends size updates through w. With compat set,
+// reported sizes are clamped to what fragile servers cope with.
+func NewNAWS(fd int, options *OptionTable, w io.Writer, compat bool) *NAWS {
+	return &NAWS{fd: fd, options: options, w: w, compat: compat}
+}
+
+// Enabled sends the current size as soon as we agree to WILL NAWS
+func (n *NAWS) Enabled(local bool) []byte {
+	if !local {
+		return nil
+	}
+	return n.frame(true)
+}
+
+// Subnegotiate ignores input; NAWS only flows from client to server
+func (n *NAWS) Subnegotiate(data []byte) []byte {
+	return nil
+}
+
+// frame builds the size subnegotiation, or nil if the size can't be read or
+// (unless force is set) hasn't changed since the last report
+func (n *NAWS) frame(force bool) []byte {
+	width, height, err := term.GetSize(n.fd)
+	if err != nil {
+		return nil
+	}
+	n.mu.Lock()
+	if !force && width == n.width && height == n.height {
+		n.mu.Unlock()
+		return nil
+	}
+	n.width, n.height = width, height
+	n.mu.Unlock()
+
+	if n.compat {
+		width = clampSize(width, nawsCompatMinWidth)
+		height = clampSize(height, nawsCompatMinHeight)
+	}
+
+	n.options.recordWindowSize(width, height)
+	// FrameSB doubles any 255 byte in the dimensions, as IAC escaping requires
+	return telnet.FrameSB(telnet.OptNAWS, []byte{byte(width >> 8), byte(width), byte(height >> 8), byte(height)})
+}
+
+// clampSize limits a dimension to [lo, nawsCompatMax]. Some embedded
+// telnetds, BusyBox's among them, never undo IAC doubling, so a 255 byte
+// shifts every later field, and some misbehave when told about a window of
+// only a few rows or columns.
+func clampSize(v, lo int) int {
+	return min(max(v, lo), nawsCompatMax)
+}
+
+// watch reports resizes until done is closed. Dragging a window edge fires
+// a storm of resize signals, so we wait until the size has been stable for
+// the debounce interval and send a single update.
+func (n *NAWS) watch(debounce time.Duration, done <-chan struct{}) {
+	resized, stop := resizeNotifications()
+	defer stop()
+	if resized == nil {
+		return
+	}
+
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	for {
+		select {
+		case <-resized:
+			timer.Reset(debounce)
+		case <-timer.C:
+			if n.options.Local(telnet.OptNAWS) {
+				if f := n.frame(false); f != nil {
+					n.w.Write(f)
+				}
+			}
+		case <-done:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// terminalFd returns the descriptor to measure the window size on. Stdout is
+// used because Windows can only size the console's output buffer.
+func terminalFd() int {
+	return int(os.Stdout.Fd())
+}
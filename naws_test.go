@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"better-telnet/telnet"
+)
+
+func TestClampSize(t *testing.T) {
+	tests := []struct {
+		v, lo, want int
+	}{
+		{80, nawsCompatMinWidth, 80},
+		{3, nawsCompatMinWidth, nawsCompatMinWidth},
+		{0, nawsCompatMinHeight, nawsCompatMinHeight},
+		{254, nawsCompatMinWidth, 254},
+		{255, nawsCompatMinWidth, 254},
+		{300, nawsCompatMinHeight, 254},
+		{65535, nawsCompatMinWidth, 254},
+	}
+	for _, tt := range tests {
+		if got := clampSize(tt.v, tt.lo); got != tt.want {
+			t.Errorf("clampSize(%d, %d) = %d, want %d", tt.v, tt.lo, got, tt.want)
+		}
+	}
+}
+
+// A clamped size never needs IAC doubling, so a server that reads NAWS as
+// a fixed 9-byte frame still finds every field where it expects it
+func TestClampedFrameHasNoIAC(t *testing.T) {
+	for v := 0; v <= 0xffff; v++ {
+		width := clampSize(v, nawsCompatMinWidth)
+		height := clampSize(v, nawsCompatMinHeight)
+		payload := []byte{byte(width >> 8), byte(width), byte(height >> 8), byte(height)}
+		if bytes.IndexByte(payload, telnet.IAC) >= 0 {
+			t.Fatalf("size %d clamps to %dx%d, which contains 255", v, width, height)
+		}
+		if f := telnet.FrameSB(telnet.OptNAWS, payload); len(f) != 9 {
+			t.Fatalf("size %d frames as %d bytes, want 9", v, len(f))
+		}
+	}
+}
+
+// Without -naws-compat a 255 is sent as is, and FrameSB escapes it
+func TestUnclampedFrameDoublesIAC(t *testing.T) {
+	f := telnet.FrameSB(telnet.OptNAWS, []byte{0, 255, 0, 24})
+	want := []byte{telnet.IAC, telnet.SB, telnet.OptNAWS, 0, 255, 255, 0, 24, telnet.IAC, telnet.SE}
+	if !bytes.Equal(f, want) {
+		t.Errorf("frame = %v, want %v", f, want)
+	}
+}
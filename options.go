@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"better-telnet/telnet"
+)
+
+// ErrRequirementFailed reports that a -require option never became active
+var ErrRequirementFailed = errors.New("required options not negotiated")
+
+// OptionTable is the session's telnet option table plus what the CLI keeps
+// about it: when each option changed, and the details reported to the
+// server, for -dump-options and the -on-option hooks.
+type OptionTable struct {
+	*telnet.Options
+
+	mu sync.Mutex
+
+	// Details reported to the server, kept for -dump-options
+	width, height int
+	termType      string
+
+	// When the connection came up, and every option change since then
+	connected time.Time
+	timings   []OptionTiming
+
+	events *EventBus
+}
+
+// OptionTiming records when an option changed state, relative to connect.
+// Slow devices often stall on one particular step of the handshake.
+type OptionTiming struct {
+	Option  string  `json:"option"`
+	Side    string  `json:"side"`
+	Enabled bool    `json:"enabled"`
+	Millis  float64 `json:"elapsed_ms"`
+}
+
+// NewOptionTable returns a table with our default option support. Option
+// timings are measured from connected, and every change is published on
+// events.
+func NewOptionTable(events *EventBus, connected time.Time) *OptionTable {
+	t := &OptionTable{Options: telnet.NewOptions(), events: events, connected: connected}
+	t.OnChange = t.changed
+	t.OnProtocolError = func(opt byte, detail string) {
+		events.Emit(Event{Kind: EventProtocolError, Option: telnet.OptionName(opt), Detail: detail})
+	}
+	return t
+}
+
+// accept agrees to opts in both directions, without a handler. Call it
+// before registering the handled options, which keep their own settings.
+func (t *OptionTable) accept(opts []byte) {
+	for _, opt := range opts {
+		t.Register(opt, nil, true, true)
+	}
+}
+
+// changed records an option turning on or off and publishes it
+func (t *OptionTable) changed(opt byte, local, on bool) {
+	side := "remote"
+	if local {
+		side = "local"
+	}
+	now := time.Now()
+	elapsed := now.Sub(t.connected)
+	t.mu.Lock()
+	t.timings = append(t.timings, OptionTiming{
+		Option:  telnet.OptionName(opt),
+		Side:    side,
+		Enabled: on,
+		Millis:  float64(elapsed.Microseconds()) / 1000,
+	})
+	t.mu.Unlock()
+
+	kind := EventOptionDisabled
+	if on {
+		kind = EventOptionEnabled
+	}
+	t.events.Emit(Event{Time: now, Kind: kind, Option: telnet.OptionName(opt), Detail: side, Elapsed: elapsed})
+}
+
+// recordWindowSize remembers the size last reported to the server via NAWS
+func (t *OptionTable) recordWindowSize(width, height int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.width, t.height = width, height
+}
+
+// recordTerminalType remembers the terminal type last sent to the server
+func (t *OptionTable) recordTerminalType(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.termType = name
+}
+
+// OptionDump is the JSON form of the negotiated state written by -dump-options
+type OptionDump struct {
+	Host         string    `json:"host"`
+	Port         string    `json:"port"`
+	Ended        time.Time `json:"ended"`
+	Local        []string  `json:"local"`  // Options we performed
+	Remote       []string  `json:"remote"` // Options the server performed
+	Width        int       `json:"naws_width,omitempty"`
+	Height       int       `json:"naws_height,omitempty"`
+	TerminalType string    `json:"terminal_type,omitempty"`
+	Charset      string    `json:"charset"`
+
+	Timings []OptionTiming `json:"timings"` // Every option change, in order
+}
+
+// writeDump saves the final option state to path as JSON
+func (t *OptionTable) writeDump(path string, config Config, charset *Charset) error {
+	local, remote := t.Active()
+	t.mu.Lock()
+	dump := OptionDump{
+		Host:         config.Host,
+		Port:         config.Port,
+		Ended:        time.Now(),
+		Local:        append([]string{}, local...),
+		Remote:       append([]string{}, remote...),
+		Width:        t.width,
+		Height:       t.height,
+		TerminalType: t.termType,
+		Charset:      charset.Current(),
+		Timings:      append([]OptionTiming{}, t.timings...),
+	}
+	t.mu.Unlock()
+
+	data, err := json.MarshalIndent(dump, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
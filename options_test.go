@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"better-telnet/telnet"
+)
+
+// negotiate feeds input to a telnet.Conn using options until the server
+// end closes, and returns what the client sent back
+func negotiate(t *testing.T, options *OptionTable, input []byte) []byte {
+	t.Helper()
+	client, server := net.Pipe()
+	defer client.Close()
+	conn := telnet.NewConn(client, options.Options)
+	replies := make(chan []byte, 1)
+	go func() {
+		b, _ := io.ReadAll(server)
+		replies <- b
+	}()
+	go func() {
+		server.Write(input)
+		// Let the client's replies through before hanging up
+		time.Sleep(50 * time.Millisecond)
+		server.Close()
+	}()
+	io.Copy(io.Discard, conn)
+	return <-replies
+}
+
+func TestRequire(t *testing.T) {
+	binary := []byte{telnet.OptBinary}
+	tests := []struct {
+		name    string
+		input   []byte
+		missing []string
+	}{
+		{"satisfied", []byte{telnet.IAC, telnet.WILL, telnet.OptBinary}, nil},
+		{"satisfied locally", []byte{telnet.IAC, telnet.DO, telnet.OptBinary}, nil},
+		{"refused", []byte{telnet.IAC, telnet.WONT, telnet.OptBinary}, []string{"BINARY"}},
+		{"never mentioned", []byte("login: "), []string{"BINARY"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := NewOptionTable(&EventBus{}, time.Now())
+			options.accept(binary)
+			negotiate(t, options, tt.input)
+			if got := options.Missing(binary); !reflect.DeepEqual(got, tt.missing) {
+				t.Errorf("Missing = %v, want %v", got, tt.missing)
+			}
+		})
+	}
+}
+
+func TestAcceptKeepsHandlers(t *testing.T) {
+	options := NewOptionTable(&EventBus{}, time.Now())
+	options.accept([]byte{telnet.OptNAWS})
+	naws := NewNAWS(-1, options, io.Discard, false)
+	options.Register(telnet.OptNAWS, naws, true, false)
+	// The peer can't perform NAWS for us, even though -require named it
+	got := negotiate(t, options, []byte{telnet.IAC, telnet.WILL, telnet.OptNAWS})
+	if want := []byte{telnet.IAC, telnet.DONT, telnet.OptNAWS}; !reflect.DeepEqual(got, want) {
+		t.Errorf("reply = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"io"
+)
+
+// defaultPagerPatterns are the pager prompts -handle-pager knows about
+// unless -pager-pattern replaces them
+var defaultPagerPatterns = []string{
+	"--More--",
+	"-- More --",
+	"---- More ----",
+	"<--- More --->",
+	"Press any key to continue",
+}
+
+// pagerWriter answers pager prompts in the server's output by sending a
+// key, so long command output scrolls by without anyone at the keyboard.
+// Prompts are matched across writes; with strip set, the part of a prompt
+// that arrives in the write completing the match is removed from the
+// output (anything shown by an earlier write stays).
+type pagerWriter struct {
+	w        io.Writer
+	reply    io.Writer // Where the advance key goes
+	key      []byte
+	patterns [][]byte
+	strip    bool
+
+	tail    []byte // End of the previous writes, for prompts split across them
+	maxTail int
+}
+
+func newPagerWriter(w, reply io.Writer, key []byte, patterns []string, strip bool) *pagerWriter {
+	p := &pagerWriter{w: w, reply: reply, key: key, strip: strip}
+	for _, pat := range patterns {
+		p.patterns = append(p.patterns, []byte(pat))
+		p.maxTail = max(p.maxTail, len(pat)-1)
+	}
+	return p
+}
+
+func (p *pagerWriter) Write(b []byte) (int, error) {
+	search := append(p.tail, b...)
+	offset := len(p.tail) // Where b starts in search
+
+	out := b
+	var kept []byte
+	from := 0
+	for pos := 0; pos < len(search); {
+		start, pat := p.nextMatch(search[pos:])
+		if pat == nil {
+			break
+		}
+		start += pos
+		end := start + len(pat)
+		if end > offset { // Only matches finished by this write are new
+			p.reply.Write(p.key)
+			if p.strip {
+				cut := max(start-offset, 0)
+				kept = append(kept, b[from:cut]...)
+				from = end - offset
+			}
+		}
+		pos = end
+	}
+	if p.strip && from > 0 {
+		out = append(kept, b[from:]...)
+	}
+
+	if keep := min(len(search), p.maxTail); keep > 0 {
+		p.tail = append(p.tail[:0], search[len(search)-keep:]...)
+	} else {
+		p.tail = p.tail[:0]
+	}
+
+	if _, err := p.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// nextMatch finds the earliest pattern in b
+func (p *pagerWriter) nextMatch(b []byte) (int, []byte) {
+	best, bestAt := []byte(nil), -1
+	for _, pat := range p.patterns {
+		if i := bytes.Index(b, pat); i >= 0 && (bestAt < 0 || i < bestAt) {
+			best, bestAt = pat, i
+		}
+	}
+	return bestAt, best
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPagerWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		strip  bool
+		want   string
+		keys   int
+	}{
+		{"no prompt", []string{"line 1\r\nline 2\r\n"}, false, "line 1\r\nline 2\r\n", 0},
+		{"prompt", []string{"line 1\r\n--More--"}, false, "line 1\r\n--More--", 1},
+		{"prompt stripped", []string{"line 1\r\n--More--"}, true, "line 1\r\n", 1},
+		{"stripped mid-write", []string{"a\r\n--More--\rb\r\n"}, true, "a\r\n\rb\r\n", 1},
+		{"split prompt", []string{"a\r\n--Mo", "re--"}, false, "a\r\n--More--", 1},
+		// The half already shown can't be taken back
+		{"split prompt stripped", []string{"a\r\n--Mo", "re--"}, true, "a\r\n--Mo", 1},
+		{"split one byte at a time", strings.Split("--More--", ""), false, "--More--", 1},
+		{"two prompts", []string{"--More--x--More--"}, true, "x", 2},
+		{"prompt not counted twice", []string{"--More--", "next page"}, false, "--More--next page", 1},
+		{"other pattern", []string{"Press any key to continue"}, true, "", 1},
+		{"near miss", []string{"--Mor", "e-"}, false, "--More-", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out, reply bytes.Buffer
+			p := newPagerWriter(&out, &reply, []byte(" "), defaultPagerPatterns, tt.strip)
+			for _, s := range tt.writes {
+				if n, err := p.Write([]byte(s)); err != nil || n != len(s) {
+					t.Fatalf("Write = %d, %v", n, err)
+				}
+			}
+			if got := out.String(); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+			if got := strings.Repeat(" ", tt.keys); reply.String() != got {
+				t.Errorf("keys sent = %q, want %q", reply.String(), got)
+			}
+		})
+	}
+}
+
+// pager plays a server that shows one page at a time and waits for a key
+// at each prompt
+type pager struct {
+	pages  []string
+	prompt string
+	out    *pagerWriter
+	keys   int // Keys received and not yet answered
+}
+
+func (s *pager) Write(key []byte) (int, error) {
+	s.keys += len(key)
+	return len(key), nil
+}
+
+// next sends the following page, with a prompt unless it is the last
+func (s *pager) next() {
+	page := s.pages[0]
+	s.pages = s.pages[1:]
+	if len(s.pages) > 0 {
+		// Split the prompt across two writes, as a slow link would
+		half := len(s.prompt) / 2
+		s.out.Write([]byte(page + s.prompt[:half]))
+		s.out.Write([]byte(s.prompt[half:]))
+		return
+	}
+	s.out.Write([]byte(page))
+}
+
+func TestPagerWriterPages(t *testing.T) {
+	pages := []string{"page 1\r\n", "page 2\r\n", "page 3\r\n"}
+	for _, strip := range []bool{false, true} {
+		var out bytes.Buffer
+		s := &pager{pages: append([]string(nil), pages...), prompt: "--More--"}
+		s.out = newPagerWriter(&out, s, []byte(" "), defaultPagerPatterns, strip)
+		s.next()
+		for s.keys > 0 && len(s.pages) > 0 {
+			s.keys--
+			s.next()
+		}
+		if len(s.pages) != 0 || s.keys != 0 {
+			t.Errorf("strip=%v: %d pages never shown, %d extra keys", strip, len(s.pages), s.keys)
+		}
+		want := "page 1\r\n--More--page 2\r\n--More--page 3\r\n"
+		if strip {
+			want = "page 1\r\n--Mopage 2\r\n--Mopage 3\r\n"
+		}
+		if got := out.String(); got != want {
+			t.Errorf("strip=%v: output = %q, want %q", strip, got, want)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// proxyHandshakeTimeout bounds the exchange with a proxy before the
+// connection to the target is up
+const proxyHandshakeTimeout = 10 * time.Second
+
+// Dialer opens the byte stream the telnet session runs over. Proxies wrap
+// another Dialer, so transports stack: TCP, then SOCKS5 or HTTP CONNECT.
+type Dialer interface {
+	Dial(network, addr string) (net.Conn, error)
+}
+
+// directDialer connects straight to the address
+type directDialer struct {
+	timeout time.Duration
+}
+
+func (d directDialer) Dial(network, addr string) (net.Conn, error) {
+	return net.DialTimeout(network, addr, d.timeout)
+}
+
+// parseProxy checks a -proxy URL: socks5://[user:pass@]host[:port] or
+// http://[user:pass@]host[:port]
+func parseProxy(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "socks5", "socks5h", "http":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q (want socks5 or http)", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("proxy URL has no host")
+	}
+	return u, nil
+}
+
+// newDialer builds the Dialer for config: direct TCP, or through -proxy
+func newDialer(config Config) Dialer {
+	var d Dialer = directDialer{timeout: 5 * time.Second}
+	if config.Proxy == nil {
+		return d
+	}
+	u := config.Proxy
+	user, pass := u.User.Username(), ""
+	if p, ok := u.User.Password(); ok {
+		pass = p
+	}
+	if u.Scheme == "http" {
+		return &httpProxyDialer{addr: proxyAddr(u, "8080"), user: user, pass: pass, forward: d}
+	}
+	return &socks5Dialer{addr: proxyAddr(u, "1080"), user: user, pass: pass, forward: d}
+}
+
+// proxyAddr returns the proxy's host:port, filling in the usual port
+func proxyAddr(u *url.URL, defPort string) string {
+	port := u.Port()
+	if port == "" {
+		port = defPort
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// socks5Dialer connects through a SOCKS5 proxy (RFC 1928), with optional
+// username/password authentication (RFC 1929). Host names are passed to
+// the proxy unresolved, so it does the DNS lookup.
+type socks5Dialer struct {
+	addr       string
+	user, pass string
+	forward    Dialer
+}
+
+// SOCKS5 protocol values
+const (
+	socksVersion        = 5
+	socksNoAuth         = 0
+	socksUserPass       = 2
+	socksNoAcceptable   = 0xff
+	socksConnect        = 1
+	socksAtypIPv4       = 1
+	socksAtypDomain     = 3
+	socksAtypIPv6       = 4
+	socksUserPassVer    = 1
+	socksReplySucceeded = 0
+)
+
+var socksReplies = map[byte]string{
+	1: "general SOCKS server failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+func (d *socks5Dialer) Dial(network, addr string) (net.Conn, error) {
+	conn, err := d.forward.Dial(network, d.addr)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(proxyHandshakeTimeout))
+	if err := d.handshake(conn, addr); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("SOCKS5 proxy %s: %v", d.addr, err)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// handshake negotiates authentication and asks the proxy to connect to addr
+func (d *socks5Dialer) handshake(conn net.Conn, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port %q", portStr)
+	}
+
+	methods := []byte{socksNoAuth}
+	if d.user != "" {
+		methods = []byte{socksUserPass}
+	}
+	if _, err := conn.Write(append([]byte{socksVersion, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != socksVersion {
+		return fmt.Errorf("not a SOCKS5 server (version %d)", reply[0])
+	}
+	switch reply[1] {
+	case socksNoAuth:
+	case socksUserPass:
+		if err := d.authenticate(conn); err != nil {
+			return err
+		}
+	case socksNoAcceptable:
+		if d.user == "" {
+			return errors.New("proxy requires authentication")
+		}
+		return errors.New("proxy refused username/password authentication")
+	default:
+		return fmt.Errorf("proxy chose unsupported method %d", reply[1])
+	}
+
+	req := []byte{socksVersion, socksConnect, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return errors.New("host name too long")
+		}
+		req = append(req, socksAtypDomain, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, socksAtypIPv4)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, socksAtypIPv6)
+		req = append(req, ip.To16()...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return err
+	}
+	if head[1] != socksReplySucceeded {
+		if msg, ok := socksReplies[head[1]]; ok {
+			return errors.New(msg)
+		}
+		return fmt.Errorf("connect failed (reply %d)", head[1])
+	}
+	// Skip the bound address the proxy reports; we have no use for it
+	var skip int
+	switch head[3] {
+	case socksAtypIPv4:
+		skip = net.IPv4len
+	case socksAtypIPv6:
+		skip = net.IPv6len
+	case socksAtypDomain:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return err
+		}
+		skip = int(n[0])
+	default:
+		return fmt.Errorf("unknown address type %d in reply", head[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
+
+// authenticate runs the username/password subnegotiation
+func (d *socks5Dialer) authenticate(conn net.Conn) error {
+	if len(d.user) > 255 || len(d.pass) > 255 {
+		return errors.New("username or password too long")
+	}
+	req := []byte{socksUserPassVer, byte(len(d.user))}
+	req = append(req, d.user...)
+	req = append(req, byte(len(d.pass)))
+	req = append(req, d.pass...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != 0 {
+		return errors.New("authentication failed")
+	}
+	return nil
+}
+
+// httpProxyDialer tunnels through an HTTP proxy with CONNECT
+type httpProxyDialer struct {
+	addr       string
+	user, pass string
+	forward    Dialer
+}
+
+func (d *httpProxyDialer) Dial(network, addr string) (net.Conn, error) {
+	conn, err := d.forward.Dial(network, d.addr)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(proxyHandshakeTimeout))
+	r, err := d.connect(conn, addr)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("HTTP proxy %s: %v", d.addr, err)
+	}
+	conn.SetDeadline(time.Time{})
+	if r.Buffered() > 0 {
+		// The server spoke first and its greeting arrived with the headers
+		return &bufferedConn{Conn: conn, r: r}, nil
+	}
+	return conn, nil
+}
+
+// connect sends the CONNECT request and checks for a 2xx answer
+func (d *httpProxyDialer) connect(conn net.Conn, addr string) (*bufio.Reader, error) {
+	var req strings.Builder
+	fmt.Fprintf(&req, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n", addr, addr)
+	if d.user != "" {
+		cred := base64.StdEncoding.EncodeToString([]byte(d.user + ":" + d.pass))
+		fmt.Fprintf(&req, "Proxy-Authorization: Basic %s\r\n", cred)
+	}
+	req.WriteString("\r\n")
+	if _, err := io.WriteString(conn, req.String()); err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	tp := textproto.NewReader(r)
+	status, err := tp.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tp.ReadMIMEHeader(); err != nil {
+		return nil, err
+	}
+	// "HTTP/1.1 200 Connection established"
+	proto, rest, _ := strings.Cut(status, " ")
+	if !strings.HasPrefix(proto, "HTTP/") {
+		return nil, fmt.Errorf("not an HTTP proxy (%q)", status)
+	}
+	if !strings.HasPrefix(rest, "2") {
+		return nil, fmt.Errorf("CONNECT refused: %s", rest)
+	}
+	return r, nil
+}
+
+// bufferedConn is a connection whose first bytes were already read into r
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	if c.r.Buffered() > 0 {
+		return c.r.Read(p)
+	}
+	return c.Conn.Read(p)
+}
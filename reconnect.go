@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"better-telnet/telnet"
+)
+
+// Bounds of the -reconnect backoff
+const (
+	reconnectMinDelay = time.Second
+	reconnectMaxDelay = 30 * time.Second
+)
+
+var (
+	// errReconnectAborted ends the session when the user gives up waiting
+	errReconnectAborted = errors.New("reconnect cancelled by user")
+	// errInputClosed is returned by the keyboard pump when stdin reaches EOF
+	errInputClosed = errors.New("standard input closed")
+	// errReconnectRefused ends the session when redialing can't succeed
+	errReconnectRefused = errors.New("reconnect refused")
+	// errInterrupted ends the session on SIGINT (Ctrl+C in cooked mode) or SIGTERM
+	errInterrupted = errors.New("interrupted")
+)
+
+// reconnectable reports whether err means the connection was lost, as
+// opposed to the session being ended on purpose or failing a check that a
+// new connection would fail too
+func reconnectable(err error) bool {
+	for _, final := range []error{errUserQuit, errScriptQuit, errInputClosed, errInterrupted, ErrScriptFailed,
+		ErrScriptTimeout, ErrRequirementFailed, telnet.ErrNegotiationFlood} {
+		if errors.Is(err, final) {
+			return false
+		}
+	}
+	return true
+}
+
+// redialFinal reports whether a failed dial would fail the same way every
+// time: the server's certificate was refused, which waiting won't change
+func redialFinal(err error) bool {
+	var verify *tls.CertificateVerificationError
+	return errors.Is(err, ErrFingerprintChanged) || errors.As(err, &verify)
+}
+
+// backoff doubles the wait between reconnect attempts up to reconnectMaxDelay
+type backoff struct {
+	delay time.Duration
+}
+
+// next returns the wait before the next attempt
+func (b *backoff) next() time.Duration {
+	d := max(b.delay, reconnectMinDelay)
+	b.delay = min(d*2, reconnectMaxDelay)
+	return d
+}
+
+// reset starts over from reconnectMinDelay
+func (b *backoff) reset() {
+	b.delay = 0
+}
+
+// redial dials the server again until it answers, backing off between
+// attempts. Progress goes to w, the screen stream, so it is recorded too.
+// The keyboard goroutine owns stdin by now, so -choose-address walks the
+// addresses in order instead of showing its menu.
+func (s *Session) redial(w io.Writer, b *backoff) (net.Conn, error) {
+	for {
+		delay := b.next()
+		fmt.Fprintf(w, "[*] %s\r\n", tr("Reconnecting in %s...", delay))
+		if !s.waitReconnect(delay) {
+			return nil, errReconnectAborted
+		}
+		conn, err := dialTarget(s.config, false)
+		if err == nil {
+			return conn, nil
+		}
+		fmt.Fprintf(w, "[-] %s\r\n", tr("Reconnect failed: %v", err))
+		if redialFinal(err) {
+			return nil, fmt.Errorf("%w: %v", errReconnectRefused, err)
+		}
+	}
+}
+
+// waitReconnect sleeps for delay and reports whether to go on. The escape
+// character or Ctrl+C gives up instead, as there is no server to send
+// them to.
+func (s *Session) waitReconnect(delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	if s.config.Batch {
+		<-timer.C
+		return true
+	}
+
+	stop := make(chan struct{})
+	abort := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		for {
+			chunk, err := s.keyboard.Next(stop)
+			if err == errSessionDone {
+				return
+			}
+			// Blocked keys can't give up either, as they couldn't quit a session
+			chunk = s.config.BlockKeys.filter(chunk)
+			if err != nil || bytes.IndexByte(chunk, CtrlC) >= 0 ||
+				(s.config.Escape != NoEscape && bytes.IndexByte(chunk, byte(s.config.Escape)) >= 0) {
+				close(abort)
+				return
+			}
+		}
+	}()
+
+	ok := true
+	select {
+	case <-timer.C:
+	case <-abort:
+		ok = false
+	}
+	close(stop)
+	<-finished
+	return ok
+}
+
+// renew returns a Session for the next connection. The local side carries
+// over: terminal mode, escape character and the rest of the config.
+func (s *Session) renew() *Session {
+	next := *s
+	next.conn, next.options, next.charset = nil, nil, nil
+	// The new server hasn't been asked to stop echoing
+	next.serverEcho = false
+	next.done = make(chan struct{})
+	return &next
+}
+
+// liveConn tracks the connection in use, so the signal handler can end
+// the session by closing it
+type liveConn struct {
+	mu          sync.Mutex
+	conn        net.Conn
+	interrupted bool
+}
+
+// set makes conn the one in use. After an interrupt it is closed at once,
+// so a redial that was under way doesn't bring the session back.
+func (l *liveConn) set(conn net.Conn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.conn = conn
+	if l.interrupted {
+		conn.Close()
+	}
+}
+
+// interrupt closes the connection in use, which ends serve, and marks the
+// session as interrupted
+func (l *liveConn) interrupt() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.interrupted = true
+	l.conn.Close()
+}
+
+func (l *liveConn) wasInterrupted() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.interrupted
+}
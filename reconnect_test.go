@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"better-telnet/telnet"
+)
+
+func TestReconnectable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, true}, // EOF from the server
+		{io.ErrUnexpectedEOF, true},
+		{&telnet.WriteError{Err: errors.New("broken pipe")}, true},
+		{ErrPeerDead, true},
+		{errUserQuit, false},
+		{errInputClosed, false},
+		{errInterrupted, false},
+		{fmt.Errorf("%w: line 3", ErrScriptFailed), false},
+		{fmt.Errorf("%w: BINARY", ErrRequirementFailed), false},
+		{telnet.ErrNegotiationFlood, false},
+	}
+	for _, tt := range tests {
+		if got := reconnectable(tt.err); got != tt.want {
+			t.Errorf("reconnectable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRedialFinal(t *testing.T) {
+	verify := &tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}}
+	for _, err := range []error{ErrFingerprintChanged, fmt.Errorf("handshake: %w", verify)} {
+		if !redialFinal(err) {
+			t.Errorf("redialFinal(%v) = false", err)
+		}
+	}
+	if redialFinal(errors.New("connection refused")) {
+		t.Error("redialFinal(connection refused) = true")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	var b backoff
+	var got []time.Duration
+	for i := 0; i < 7; i++ {
+		got = append(got, b.next())
+	}
+	want := []time.Duration{1, 2, 4, 8, 16, 30, 30}
+	for i := range want {
+		if got[i] != want[i]*time.Second {
+			t.Fatalf("delays = %v, want %v seconds", got, want)
+		}
+	}
+	b.reset()
+	if d := b.next(); d != reconnectMinDelay {
+		t.Errorf("after reset: %v, want %v", d, reconnectMinDelay)
+	}
+}
+
+// Keys blocked by -block-keys can't cancel the wait any more than they
+// can quit a session
+func TestWaitReconnectBlockedKeys(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+	s := &Session{keyboard: NewKeyboard(r)}
+	s.config.Escape = DefaultEscapeChar
+	s.config.BlockKeys[DefaultEscapeChar] = true
+	s.config.BlockKeys[CtrlC] = true
+	go w.Write([]byte{DefaultEscapeChar, CtrlC})
+	if !s.waitReconnect(100 * time.Millisecond) {
+		t.Error("blocked keys cancelled the reconnect")
+	}
+	s.config.BlockKeys = KeySet{}
+	go w.Write([]byte{DefaultEscapeChar})
+	if s.waitReconnect(5 * time.Second) {
+		t.Error("escape did not cancel the reconnect")
+	}
+}
+
+// A signal hangs up the connection in use, and any a redial brings back
+func TestLiveConnInterrupt(t *testing.T) {
+	first, peer := net.Pipe()
+	defer peer.Close()
+	live := &liveConn{}
+	live.set(first)
+	if live.wasInterrupted() {
+		t.Fatal("interrupted before any signal")
+	}
+	live.interrupt()
+	if _, err := first.Write([]byte("x")); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("write after interrupt = %v, want io.ErrClosedPipe", err)
+	}
+	second, peer2 := net.Pipe()
+	defer peer2.Close()
+	live.set(second)
+	if _, err := second.Write([]byte("x")); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("write on the redialed conn = %v, want io.ErrClosedPipe", err)
+	}
+	if !live.wasInterrupted() {
+		t.Error("interrupt not recorded")
+	}
+}
+
+// Each reconnect points the title at the session again, without pushing
+// a second copy of the original title
+func TestReconnectRetitles(t *testing.T) {
+	titleSaved = false
+	t.Cleanup(func() { titleSaved = false })
+	config := Config{Host: "router", Port: "23"}
+	var screen, log, title bytes.Buffer
+	writeTitle(&title, config.Host, config.Port) // At connect
+	for i := 0; i < 2; i++ {
+		announceRestored(config, &screen, &log, &title, time.Now())
+	}
+	set := fmt.Sprintf(AnsiSetTitle, "bettertelnet: router:23")
+	if got := strings.Count(title.String(), set); got != 3 {
+		t.Errorf("title set %d times, want 3 (%q)", got, title.String())
+	}
+	if got := strings.Count(title.String(), AnsiPushTitle); got != 1 {
+		t.Errorf("title pushed %d times, want 1", got)
+	}
+	if got := strings.Count(screen.String(), "router:23"); got != 2 {
+		t.Errorf("screen = %q, want two banners", screen.String())
+	}
+	if got := strings.Count(log.String(), "Session Restored"); got != 2 {
+		t.Errorf("log = %q, want two markers", log.String())
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// castHeader is the first line of an asciicast v2 file
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Recorder writes the session as an asciicast v2 file: a JSON header line,
+// then one [seconds, "o"|"i", data] line per chunk of output or input.
+// Players such as asciinema show the "o" events; "i" events keep what was
+// typed for auditing.
+type Recorder struct {
+	mu    sync.Mutex
+	f     *os.File
+	start time.Time
+	err   error // First write error; recording stops after it
+}
+
+// newRecorder creates path and writes the header, sized to the terminal
+func newRecorder(path, title string) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	width, height, err := term.GetSize(terminalFd())
+	if err != nil {
+		width, height = 80, 24
+	}
+	r := &Recorder{f: f, start: time.Now()}
+	header, _ := json.Marshal(castHeader{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: r.start.Unix(),
+		Title:     title,
+		Env:       map[string]string{"TERM": os.Getenv("TERM")},
+	})
+	if _, err := f.Write(append(header, '\n')); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// event appends one frame. Errors are kept rather than returned, so a full
+// disk never breaks the session itself.
+func (r *Recorder) event(kind string, data []byte) {
+	if r == nil || len(data) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	// json.Marshal turns invalid UTF-8 into U+FFFD, as the format requires text
+	text, _ := json.Marshal(string(data))
+	_, r.err = fmt.Fprintf(r.f, "[%.6f, %q, %s]\n", time.Since(r.start).Seconds(), kind, text)
+}
+
+// Output returns a writer that records everything written as output
+func (r *Recorder) Output() io.Writer {
+	return recordWriter{r}
+}
+
+type recordWriter struct{ r *Recorder }
+
+func (w recordWriter) Write(p []byte) (int, error) {
+	w.r.event("o", p)
+	return len(p), nil
+}
+
+// Close finishes the file and reports the first error seen while recording
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.f.Close(); r.err == nil {
+		r.err = err
+	}
+	return r.err
+}
+
+// runReplay implements "btel replay": play a recording's output back with
+// its original timing, scaled by -speed
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	speed := fs.Float64("speed", 1, "Playback speed multiplier, e.g. 2 for twice as fast")
+	maxIdle := fs.Duration("max-idle", 0, "Cap pauses between frames at this long (0 = keep the recorded pauses)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s replay [options] <file.cast>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	setLanguage("")
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return ExitError
+	}
+	if *speed <= 0 {
+		fmt.Fprintln(os.Stderr, "[-] -speed must be positive")
+		return ExitError
+	}
+	if err := replay(fs.Arg(0), os.Stdout, *speed, *maxIdle); err != nil {
+		fmt.Fprintf(os.Stderr, "[-] %s\n", tr("Replay failed: %v", err))
+		return ExitError
+	}
+	return ExitOK
+}
+
+// replay copies the output frames of the cast file at path to w
+func replay(path string, w io.Writer, speed float64, maxIdle time.Duration) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		return errors.New("empty file")
+	}
+	var header castHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return fmt.Errorf("bad header: %v", err)
+	}
+	if header.Version != 2 {
+		return fmt.Errorf("unsupported asciicast version %d (want 2)", header.Version)
+	}
+
+	var last float64
+	for lineNo := 2; scanner.Scan(); lineNo++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var frame []json.RawMessage
+		var at float64
+		var kind, data string
+		if err := json.Unmarshal(scanner.Bytes(), &frame); err != nil || len(frame) < 3 ||
+			json.Unmarshal(frame[0], &at) != nil || json.Unmarshal(frame[1], &kind) != nil ||
+			json.Unmarshal(frame[2], &data) != nil {
+			return fmt.Errorf("line %d: malformed event", lineNo)
+		}
+		if kind != "o" {
+			continue
+		}
+		pause := time.Duration((at - last) / speed * float64(time.Second))
+		if maxIdle > 0 {
+			pause = min(pause, maxIdle)
+		}
+		last = at
+		time.Sleep(pause)
+		if _, err := io.WriteString(w, data); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,44 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// resizePollInterval is how often the console size is checked
+const resizePollInterval = 250 * time.Millisecond
+
+// resizeNotifications polls the console, since Windows has no SIGWINCH. A
+// value is delivered only when the size actually changes, so the debounce
+// in NAWS.watch still sees a quiet period after each resize.
+func resizeNotifications() (<-chan os.Signal, func()) {
+	c := make(chan os.Signal, 1)
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(resizePollInterval)
+		defer ticker.Stop()
+		width, height, _ := term.GetSize(terminalFd())
+		for {
+			select {
+			case <-ticker.C:
+				w, h, err := term.GetSize(terminalFd())
+				if err != nil || (w == width && h == height) {
+					continue
+				}
+				width, height = w, h
+				select {
+				case c <- syscall.Signal(0):
+				default:
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return c, func() { close(stop) }
+}
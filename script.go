@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultExpectTimeout applies to expect steps that don't give their own,
+// unless -expect-timeout says otherwise
+const DefaultExpectTimeout = 10 * time.Second
+
+// expectWindow bounds how much unmatched output an expect step can search
+const expectWindow = 64 << 10
+
+var (
+	// ErrScriptFailed is returned when a script runs a fail step
+	ErrScriptFailed = errors.New("script failed")
+	// ErrScriptTimeout is returned when an expect step sees none of its patterns in time
+	ErrScriptTimeout = errors.New("script timed out")
+	// errScriptQuit ends the session successfully from a script
+	errScriptQuit = errors.New("quit by script")
+)
+
+// Script is a parsed -script file: a list of steps run in order against
+// the session. A script looks like
+//
+//	expect "login:" 5s
+//	send "admin\r"
+//	expect 10s
+//	    "Password:"          send "secret\r"
+//	    "Permission denied"  fail "login rejected"
+//	    timeout              fail "no password prompt"
+//	end
+//	expect "#"
+//
+// An expect with a pattern waits for it. An expect without one opens a
+// block of alternatives, each a pattern followed by the single step to run
+// when that pattern is seen first; "timeout" picks the step to run when
+// nothing matches. Without a timeout branch the script fails on timeout.
+type Script struct {
+	steps []scriptStep
+}
+
+// scriptStep is one line of a script, or one expect block
+type scriptStep struct {
+	line int
+	kind string // "expect", "send", "sleep", "fail" or "quit"
+	text string // Data to send, or the fail message
+
+	// For expect: the alternatives and their steps. A plain
+	// 'expect "x"' is a block with one alternative and no action.
+	timeout   time.Duration
+	patterns  []string
+	actions   []*scriptStep // nil entry = no action
+	onTimeout *scriptStep
+	catchTime bool // a timeout branch was given
+}
+
+// loadScript reads and parses a script file. Expect steps without a
+// timeout of their own wait for defTimeout.
+func loadScript(path string, defTimeout time.Duration) (*Script, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := &Script{}
+	var block *scriptStep
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		words, err := splitScriptLine(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, lineNo, err)
+		}
+		if len(words) == 0 {
+			continue
+		}
+
+		if block != nil {
+			err = block.addBranch(words, lineNo, defTimeout)
+			if err == errBlockEnd {
+				s.steps = append(s.steps, *block)
+				block = nil
+				continue
+			}
+		} else {
+			var step *scriptStep
+			step, err = parseScriptStep(words, lineNo, defTimeout)
+			if err == nil && step.kind == "expect" && len(step.patterns) == 0 {
+				block = step
+				continue
+			}
+			if err == nil {
+				s.steps = append(s.steps, *step)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, lineNo, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if block != nil {
+		return nil, fmt.Errorf("%s:%d: expect block is missing \"end\"", path, block.line)
+	}
+	return s, nil
+}
+
+// errBlockEnd marks the "end" line of an expect block
+var errBlockEnd = errors.New("end of block")
+
+// addBranch parses one alternative inside an expect block
+func (b *scriptStep) addBranch(words []scriptWord, lineNo int, defTimeout time.Duration) error {
+	if !words[0].quoted {
+		switch words[0].text {
+		case "end":
+			if len(words) != 1 {
+				return errors.New("unexpected text after \"end\"")
+			}
+			if len(b.patterns) == 0 {
+				return errors.New("expect block has no patterns")
+			}
+			return errBlockEnd
+		case "timeout":
+			if b.catchTime {
+				return errors.New("duplicate timeout branch")
+			}
+			action, err := parseBranchAction(words[1:], lineNo, defTimeout)
+			if err != nil {
+				return err
+			}
+			b.catchTime = true
+			b.onTimeout = action
+			return nil
+		}
+		return fmt.Errorf("expected a quoted pattern, \"timeout\" or \"end\", got %q", words[0].text)
+	}
+	if words[0].text == "" {
+		return errors.New("empty pattern")
+	}
+	action, err := parseBranchAction(words[1:], lineNo, defTimeout)
+	if err != nil {
+		return err
+	}
+	b.patterns = append(b.patterns, words[0].text)
+	b.actions = append(b.actions, action)
+	return nil
+}
+
+// parseBranchAction parses the step run when a branch is taken
+func parseBranchAction(words []scriptWord, lineNo int, defTimeout time.Duration) (*scriptStep, error) {
+	if len(words) == 0 {
+		return nil, nil
+	}
+	step, err := parseScriptStep(words, lineNo, defTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if step.kind == "expect" {
+		return nil, errors.New("expect blocks cannot be nested")
+	}
+	return step, nil
+}
+
+// parseScriptStep parses a single top-level step
+func parseScriptStep(words []scriptWord, lineNo int, defTimeout time.Duration) (*scriptStep, error) {
+	step := &scriptStep{line: lineNo, kind: words[0].text}
+	args := words[1:]
+	if words[0].quoted {
+		return nil, fmt.Errorf("expected a command, got string %q", words[0].text)
+	}
+
+	switch step.kind {
+	case "expect":
+		step.timeout = defTimeout
+		if len(args) > 0 && args[0].quoted {
+			step.patterns = []string{args[0].text}
+			step.actions = []*scriptStep{nil}
+			args = args[1:]
+		}
+		if len(args) > 0 {
+			d, err := time.ParseDuration(args[0].text)
+			if err != nil || args[0].quoted || d <= 0 {
+				return nil, fmt.Errorf("invalid timeout %q", args[0].text)
+			}
+			step.timeout = d
+			args = args[1:]
+		}
+	case "send":
+		if len(args) == 0 || !args[0].quoted {
+			return nil, errors.New("usage: send \"text\"")
+		}
+		step.text = args[0].text
+		args = args[1:]
+	case "sleep":
+		if len(args) == 0 {
+			return nil, errors.New("usage: sleep <duration>")
+		}
+		d, err := time.ParseDuration(args[0].text)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid duration %q", args[0].text)
+		}
+		step.timeout = d
+		args = args[1:]
+	case "fail":
+		step.text = "fail"
+		if len(args) > 0 && args[0].quoted {
+			step.text = args[0].text
+			args = args[1:]
+		}
+	case "quit":
+	default:
+		return nil, fmt.Errorf("unknown command %q", step.kind)
+	}
+	if len(args) > 0 {
+		return nil, fmt.Errorf("unexpected %q", args[0].text)
+	}
+	return step, nil
+}
+
+// scriptWord is a bare word or a quoted string with escapes expanded
+type scriptWord struct {
+	text   string
+	quoted bool
+}
+
+// splitScriptLine splits a line into words, stopping at a # comment
+func splitScriptLine(line string) ([]scriptWord, error) {
+	var words []scriptWord
+	for line = strings.TrimSpace(line); line != ""; line = strings.TrimSpace(line) {
+		if line[0] == '#' {
+			break
+		}
+		if line[0] != '"' {
+			end := strings.IndexAny(line, " \t")
+			if end < 0 {
+				end = len(line)
+			}
+			words = append(words, scriptWord{text: line[:end]})
+			line = line[end:]
+			continue
+		}
+
+		end := 1
+		for end < len(line) && line[end] != '"' {
+			if line[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(line) {
+			return nil, fmt.Errorf("unterminated string %s", line)
+		}
+		text, err := unescape(line[1:end])
+		if err != nil {
+			return nil, err
+		}
+		words = append(words, scriptWord{text: string(text), quoted: true})
+		line = line[end+1:]
+	}
+	return words, nil
+}
+
+// run executes the script against the session. It returns nil when the
+// script runs to the end and the session should carry on interactively.
+func (s *Script) run(sess *Session, out *expectBuffer) error {
+	for i := range s.steps {
+		if err := s.steps[i].exec(sess, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exec runs one step
+func (st *scriptStep) exec(sess *Session, out *expectBuffer) error {
+	switch st.kind {
+	case "send":
+		return sess.write(sess.charset.encode([]byte(st.text)))
+	case "sleep":
+		select {
+		case <-time.After(st.timeout):
+			return nil
+		case <-sess.done:
+			return errSessionDone
+		}
+	case "fail":
+		return fmt.Errorf("%w: line %d: %s", ErrScriptFailed, st.line, st.text)
+	case "quit":
+		return errScriptQuit
+	}
+
+	i, err := out.wait(st.patterns, st.timeout, sess.done)
+	if err != nil {
+		return err
+	}
+	action := st.onTimeout
+	if i >= 0 {
+		action = st.actions[i]
+	} else if !st.catchTime {
+		return fmt.Errorf("%w: line %d: no match for %s within %s", ErrScriptTimeout, st.line, quoteAll(st.patterns), st.timeout)
+	}
+	if action == nil {
+		return nil
+	}
+	return action.exec(sess, out)
+}
+
+// quoteAll formats patterns for an error message
+func quoteAll(patterns []string) string {
+	quoted := make([]string, len(patterns))
+	for i, p := range patterns {
+		quoted[i] = strconv.Quote(p)
+	}
+	return strings.Join(quoted, " or ")
+}
+
+// expectBuffer collects server output for expect steps to search
+type expectBuffer struct {
+	mu      sync.Mutex
+	buf     []byte
+	changed chan struct{} // closed and replaced on every write
+}
+
+func newExpectBuffer() *expectBuffer {
+	return &expectBuffer{changed: make(chan struct{})}
+}
+
+func (e *expectBuffer) Write(p []byte) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.buf = append(e.buf, p...)
+	if over := len(e.buf) - expectWindow; over > 0 {
+		e.buf = append(e.buf[:0], e.buf[over:]...)
+	}
+	close(e.changed)
+	e.changed = make(chan struct{})
+	return len(p), nil
+}
+
+// wait blocks until one of patterns appears in the output and returns its
+// index, or -1 once timeout passes. Output up to the end of the earliest
+// match is consumed so the next expect only sees what follows.
+func (e *expectBuffer) wait(patterns []string, timeout time.Duration, done <-chan struct{}) (int, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		e.mu.Lock()
+		best, bestAt := -1, -1
+		for i, p := range patterns {
+			if at := bytes.Index(e.buf, []byte(p)); at >= 0 && (bestAt < 0 || at < bestAt) {
+				best, bestAt = i, at
+			}
+		}
+		if best >= 0 {
+			e.buf = append(e.buf[:0], e.buf[bestAt+len(patterns[best]):]...)
+			e.mu.Unlock()
+			return best, nil
+		}
+		changed := e.changed
+		e.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-deadline.C:
+			return -1, nil
+		case <-done:
+			return -1, errSessionDone
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"better-telnet/telnet"
+)
+
+const loginScript = `
+expect 200ms
+    "Password:"          send "secret\r"
+    "Permission denied"  fail "login rejected"
+    timeout              fail "no password prompt"
+end
+`
+
+// writeScript parses text as a script file
+func writeScript(t *testing.T, text string) (*Script, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "login.script")
+	os.WriteFile(path, []byte(text), 0644)
+	return loadScript(path, 100*time.Millisecond)
+}
+
+// runScript runs text against output from the server and returns what
+// the script sent and its result
+func runScript(t *testing.T, text, output string) (string, error) {
+	t.Helper()
+	script, err := writeScript(t, text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, server := net.Pipe()
+	defer server.Close()
+	options := NewOptionTable(&EventBus{}, time.Now())
+	sess := &Session{
+		conn:    telnet.NewConn(client, options.Options),
+		options: options,
+		charset: NewCharset(CharsetUTF8, &EventBus{}),
+		done:    make(chan struct{}),
+	}
+	sent := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(server)
+		sent <- string(b)
+	}()
+	expect := newExpectBuffer()
+	expect.Write([]byte(output))
+	err = script.run(sess, expect)
+	client.Close()
+	return <-sent, err
+}
+
+func TestScriptBranches(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		err    error
+		detail string
+		sent   string
+	}{
+		{"password prompt", "Password: ", nil, "", "secret\r"},
+		{"denied", "Permission denied\r\n", ErrScriptFailed, "login rejected", ""},
+		{"earliest match wins", "Permission denied\r\nPassword: ", ErrScriptFailed, "login rejected", ""},
+		{"timeout branch", "Last login: never\r\n", ErrScriptFailed, "no password prompt", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sent, err := runScript(t, loginScript, tt.output)
+			if !errors.Is(err, tt.err) || (tt.detail != "" && !strings.Contains(err.Error(), tt.detail)) {
+				t.Errorf("run = %v, want %v mentioning %q", err, tt.err, tt.detail)
+			}
+			if sent != tt.sent {
+				t.Errorf("sent %q, want %q", sent, tt.sent)
+			}
+		})
+	}
+}
+
+func TestScriptNoMatchTimeout(t *testing.T) {
+	start := time.Now()
+	_, err := runScript(t, `expect "login:"`, "Welcome\r\n")
+	if !errors.Is(err, ErrScriptTimeout) {
+		t.Fatalf("run = %v, want ErrScriptTimeout", err)
+	}
+	if !strings.Contains(err.Error(), `line 1: no match for "login:" within 100ms`) {
+		t.Errorf("error %q doesn't name the line, pattern and timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("gave up after %s, before the -expect-timeout default", elapsed)
+	}
+}
+
+// Each expect only sees output after the previous match
+func TestScriptConsumesMatches(t *testing.T) {
+	sent, err := runScript(t, "expect \"$\"\nsend \"ls\\r\"\nexpect \"$\" \nquit", "$ ")
+	if !errors.Is(err, ErrScriptTimeout) || sent != "ls\r" {
+		t.Errorf("run = %v, sent %q; want a timeout after sending ls", err, sent)
+	}
+	_, err = runScript(t, "expect \"$\"\nexpect \"$\"\nquit", "$ $ ")
+	if err != errScriptQuit {
+		t.Errorf("run = %v, want errScriptQuit", err)
+	}
+}
+
+func TestScriptParseErrors(t *testing.T) {
+	tests := []struct {
+		text string
+		err  string
+	}{
+		{"expect\n  \"a\" send \"b\"\n", `:1: expect block is missing "end"`},
+		{"expect\nend\n", "expect block has no patterns"},
+		{"expect\n  \"a\" expect \"b\"\nend\n", "cannot be nested"},
+		{"expect \"a\" soon\n", `invalid timeout "soon"`},
+		{"send hello\n", "usage: send"},
+		{"dance\n", `unknown command "dance"`},
+	}
+	for _, tt := range tests {
+		if _, err := writeScript(t, tt.text); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("script %q: error %v, want one containing %q", tt.text, err, tt.err)
+		}
+	}
+}
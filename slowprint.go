@@ -0,0 +1,107 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// slowPrintMax caps how much output may queue up behind the print rate.
+// Past it, writers block, which in turn stops us reading from the server.
+const slowPrintMax = 1 << 20
+
+// slowWriter meters output to the terminal at a fixed number of characters
+// per second, for demos and teaching. It only sits on the screen path, so
+// logs and captures still receive data as fast as it arrives.
+//
+// Output is released a whole UTF-8 character at a time, so the terminal
+// never sees half of one. Every write to w happens under mu, keeping the
+// released chunks and the final Flush in order.
+type slowWriter struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	buf  []byte
+	w    io.Writer
+	rate int // characters per second
+	done bool
+}
+
+// newSlowWriter starts metering writes to w at rate characters per second
+func newSlowWriter(w io.Writer, rate int) *slowWriter {
+	s := &slowWriter{w: w, rate: rate}
+	s.cond = sync.NewCond(&s.mu)
+	go s.run()
+	return s
+}
+
+func (s *slowWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for !s.done && len(s.buf) > 0 && len(s.buf)+len(p) > slowPrintMax {
+		s.cond.Wait()
+	}
+	if s.done {
+		return s.w.Write(p)
+	}
+	s.buf = append(s.buf, p...)
+	return len(p), nil
+}
+
+// run releases queued bytes on a steady tick
+func (s *slowWriter) run() {
+	const tick = 20 * time.Millisecond
+	perTick := float64(s.rate) * tick.Seconds()
+	budget := 0.0
+
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.mu.Lock()
+		if s.done {
+			s.mu.Unlock()
+			return
+		}
+		budget += perTick
+		n, chars := runePrefix(s.buf, int(budget))
+		if n == len(s.buf) {
+			// Don't bank up credit while idle, or the next burst prints instantly
+			budget = min(budget, float64(chars+1))
+		}
+		budget -= float64(chars)
+		if n > 0 {
+			s.w.Write(s.buf[:n])
+			s.buf = s.buf[n:]
+		}
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	}
+}
+
+// runePrefix returns how many bytes of b hold at most max characters, and
+// how many characters that is. A trailing partial character is left for
+// the next write to complete; bytes that aren't UTF-8 count one each.
+func runePrefix(b []byte, max int) (n, chars int) {
+	for chars < max && n < len(b) {
+		if !utf8.FullRune(b[n:]) {
+			break
+		}
+		_, size := utf8.DecodeRune(b[n:])
+		n += size
+		chars++
+	}
+	return n, chars
+}
+
+// Flush stops metering and writes out whatever is still queued
+func (s *slowWriter) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return
+	}
+	s.done = true
+	s.w.Write(s.buf)
+	s.buf = nil
+	s.cond.Broadcast()
+}
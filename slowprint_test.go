@@ -0,0 +1,115 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// screenRecorder keeps what reaches the terminal and flags writes that
+// overlap or split a character
+type screenRecorder struct {
+	t      *testing.T
+	busy   atomic.Bool
+	mu     sync.Mutex
+	writes []string
+}
+
+func (r *screenRecorder) Write(p []byte) (int, error) {
+	if !r.busy.CompareAndSwap(false, true) {
+		r.t.Error("overlapping writes to the terminal")
+	}
+	defer r.busy.Store(false)
+	if !utf8.Valid(p) {
+		r.t.Errorf("write %q splits a character", p)
+	}
+	time.Sleep(time.Millisecond) // Leave room for a racing write
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.writes = append(r.writes, string(p))
+	return len(p), nil
+}
+
+func (r *screenRecorder) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return strings.Join(r.writes, "")
+}
+
+// The rate counts characters: 20 three-byte characters at 100 per second
+// take about 200ms, not the 600ms their bytes would
+func TestSlowWriterPacesCharacters(t *testing.T) {
+	screen := &screenRecorder{t: t}
+	s := newSlowWriter(screen, 100)
+	defer s.Flush()
+	text := strings.Repeat("日", 20)
+	start := time.Now()
+	s.Write([]byte(text))
+	for screen.String() != text {
+		if time.Since(start) > 2*time.Second {
+			t.Fatalf("only %q shown after 2s", screen.String())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if d := time.Since(start); d < 150*time.Millisecond || d > 450*time.Millisecond {
+		t.Errorf("20 characters at 100/s took %v, want about 200ms", d)
+	}
+}
+
+func TestSlowWriterKeepsRunesWhole(t *testing.T) {
+	screen := &screenRecorder{t: t}
+	s := newSlowWriter(screen, 300)
+	text := "héllo wörld ✓ 日本語 🙂 done"
+	// A character split across writes is held until it is complete
+	b := []byte(text)
+	for i := 0; i < len(b); i += 5 {
+		s.Write(b[i:min(i+5, len(b))])
+	}
+	time.Sleep(200 * time.Millisecond)
+	s.Flush()
+	if got := screen.String(); got != text {
+		t.Errorf("screen = %q, want %q", got, text)
+	}
+}
+
+// Flush lands after anything already on its way to the terminal
+func TestSlowWriterFlushInOrder(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		screen := &screenRecorder{t: t}
+		s := newSlowWriter(screen, 5000)
+		var want strings.Builder
+		for n := 0; n < 50; n++ {
+			line := strings.Repeat(string(rune('a'+n%26)), 10) + "\r\n"
+			want.WriteString(line)
+			s.Write([]byte(line))
+		}
+		time.Sleep(time.Duration(i) * time.Millisecond)
+		s.Flush()
+		if got := screen.String(); got != want.String() {
+			t.Fatalf("run %d: output out of order:\n%q\nwant\n%q", i, got, want.String())
+		}
+	}
+}
+
+func TestRunePrefix(t *testing.T) {
+	tests := []struct {
+		b        string
+		max      int
+		n, chars int
+	}{
+		{"abc", 2, 2, 2},
+		{"日本", 1, 3, 1},
+		{"日本", 5, 6, 2},
+		{"a\xe6\x97", 3, 1, 1}, // Start of 日, rest still to come
+		{"\xffab", 2, 2, 2},    // Invalid byte counts as one
+	}
+	for _, tt := range tests {
+		n, chars := runePrefix([]byte(tt.b), tt.max)
+		if n != tt.n || chars != tt.chars {
+			t.Errorf("runePrefix(%q, %d) = %d, %d; want %d, %d", tt.b, tt.max, n, chars, tt.n, tt.chars)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package telnet
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// ReadMode controls when Conn.Read hands data back to the caller
+type ReadMode int
+
+const (
+	ReadBurst ReadMode = iota // Return once the receive buffer is drained
+	ReadLine                  // Also return at newline and EOR boundaries
+	ReadChar                  // Return after every data byte
+)
+
+// ErrNegotiationFlood is returned by Read once the negotiation limit is exceeded
+var ErrNegotiationFlood = errors.New("server exceeded the negotiation limit")
+
+// WriteError marks a failed write to the peer, as opposed to the peer
+// closing its side of the connection. Every write error from a Conn,
+// including those of negotiation replies sent during Read, is one.
+type WriteError struct {
+	Err error
+}
+
+func (e *WriteError) Error() string { return "failed to send to server: " + e.Err.Error() }
+func (e *WriteError) Unwrap() error { return e.Err }
+
+// Conn is a telnet connection. Read returns the peer's data with commands
+// and the NUL of CR NUL removed, and answers negotiation as it goes; Write
+// sends data, doubling IAC bytes. Writes are serialized, so negotiation replies, keystrokes and
+// raw commands from different goroutines never interleave mid-sequence.
+// Only one goroutine may Read at a time.
+type Conn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	options *Options
+
+	// Settings to change before the first Read
+	Mode            ReadMode
+	MaxNegotiations int // DO/DONT/WILL/WONT allowed before ErrNegotiationFlood (0 = unlimited); TIMING-MARK acks are free
+	MaxSB           int // Longer subnegotiation payloads are discarded (0 = unlimited)
+
+	wmu sync.Mutex
+
+	replies      []byte // Negotiation replies waiting to be sent
+	sb           []byte // Subnegotiation payload being collected
+	negotiations int
+	afterCR      bool // Last data byte was a CR outside BINARY mode
+}
+
+// NewConn runs the telnet protocol over conn, negotiating as options says
+func NewConn(conn net.Conn, options *Options) *Conn {
+	return &Conn{conn: conn, reader: bufio.NewReader(conn), options: options}
+}
+
+// Dial connects to addr and returns a Conn with the default options
+func Dial(network, addr string) (*Conn, error) {
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(conn, NewOptions()), nil
+}
+
+// Options returns the option table the connection negotiates with
+func (c *Conn) Options() *Options {
+	return c.options
+}
+
+// Write sends data to the peer, doubling any IAC bytes so they arrive as data
+func (c *Conn) Write(p []byte) (int, error) {
+	data := p
+	if bytes.IndexByte(p, IAC) >= 0 {
+		data = make([]byte, 0, len(p)+8)
+		for _, b := range p {
+			data = append(data, b)
+			if b == IAC {
+				data = append(data, IAC)
+			}
+		}
+	}
+	if _, err := c.WriteRaw(data); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// WriteRaw sends p unchanged: telnet commands such as IAC BRK, or
+// subnegotiations already framed with FrameSB
+func (c *Conn) WriteRaw(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	n, err := c.conn.Write(p)
+	if err != nil {
+		return n, &WriteError{err}
+	}
+	return n, nil
+}
+
+// Raw returns a writer whose writes go out through WriteRaw
+func (c *Conn) Raw() io.Writer {
+	return rawWriter{c}
+}
+
+type rawWriter struct{ c *Conn }
+
+func (w rawWriter) Write(p []byte) (int, error) { return w.c.WriteRaw(p) }
+
+func (c *Conn) Close() error                       { return c.conn.Close() }
+func (c *Conn) LocalAddr() net.Addr                { return c.conn.LocalAddr() }
+func (c *Conn) RemoteAddr() net.Addr               { return c.conn.RemoteAddr() }
+func (c *Conn) SetDeadline(t time.Time) error      { return c.conn.SetDeadline(t) }
+func (c *Conn) SetReadDeadline(t time.Time) error  { return c.conn.SetReadDeadline(t) }
+func (c *Conn) SetWriteDeadline(t time.Time) error { return c.conn.SetWriteDeadline(t) }
+
+// flushReplies sends any queued negotiation replies to the peer
+func (c *Conn) flushReplies() error {
+	if len(c.replies) == 0 {
+		return nil
+	}
+	_, err := c.WriteRaw(c.replies)
+	c.replies = c.replies[:0]
+	return err
+}
+
+// negotiate queues our answer to an option command, as decided by the option table
+func (c *Conn) negotiate(cmd, opt byte) {
+	c.replies = append(c.replies, c.options.answer(cmd, opt)...)
+}
+
+// readSubnegotiation collects everything up to IAC SE, undoing IAC doubling.
+// The returned slice starts with the option byte and is reused by the next call.
+//
+// The option byte itself is taken verbatim: for EXOPL (255) it is not an
+// IAC, and treating it as one would pair it with the following EXOPL
+// subcommand (DO/WILL/...) or, worse, with the closing IAC SE. Senders that
+// double it anyway are tolerated, since no EXOPL payload starts with 255.
+func (c *Conn) readSubnegotiation() ([]byte, error) {
+	opt, err := c.reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if opt == OptEXOPL {
+		if next, err := c.reader.Peek(2); err == nil && next[0] == IAC && next[1] != SE {
+			c.reader.ReadByte()
+		}
+	}
+	c.sb = append(c.sb[:0], opt)
+	overflow := false
+	for {
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == IAC {
+			next, err := c.reader.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if next == SE {
+				break
+			}
+			if next != IAC {
+				// Stray command inside SB; drop it like the rest of the stream would
+				continue
+			}
+		}
+		// Past the cap, keep reading to IAC SE but stop buffering so a
+		// peer can't make us hold an unbounded payload
+		if overflow {
+			continue
+		}
+		if c.MaxSB > 0 && len(c.sb)-1 >= c.MaxSB {
+			overflow = true
+			c.sb = c.sb[:1]
+			continue
+		}
+		c.sb = append(c.sb, b)
+	}
+	if overflow {
+		c.options.protocolError(opt, fmt.Sprintf("subnegotiation longer than %d bytes discarded", c.MaxSB))
+		return nil, nil
+	}
+	return c.sb, nil
+}
+
+// Read returns the peer's data with telnet commands removed. Negotiation
+// is answered along the way; a failed reply is returned as a *WriteError.
+func (c *Conn) Read(p []byte) (n int, err error) {
+	// Replies queued behind data returned by the previous call go out first
+	if err := c.flushReplies(); err != nil {
+		return 0, err
+	}
+
+	for n < len(p) {
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			return n, err
+		}
+
+		if b == IAC {
+			cmd, err := c.reader.ReadByte()
+			if err != nil {
+				return n, err
+			}
+
+			if cmd == IAC {
+				p[n] = IAC
+				n++
+			} else if cmd == DO || cmd == DONT || cmd == WILL || cmd == WONT {
+				opt, err := c.reader.ReadByte()
+				if err != nil {
+					return n, err
+				}
+				// WILL/WONT TIMING-MARK answers a probe of ours (the health
+				// check sends one per idle period) and is never replied to,
+				// so it doesn't count toward the flood limit
+				if opt != OptTimingMark || cmd == DO || cmd == DONT {
+					c.negotiations++
+				}
+				if c.MaxNegotiations > 0 && c.negotiations > c.MaxNegotiations {
+					return n, ErrNegotiationFlood
+				}
+				c.negotiate(cmd, opt)
+				// Hand any data that preceded the command to the caller before
+				// we touch the network, so a banner interleaved with
+				// negotiation is never held up by our reply
+				if n > 0 {
+					break
+				}
+				if err := c.flushReplies(); err != nil {
+					return n, err
+				}
+			} else if cmd == SB {
+				payload, err := c.readSubnegotiation()
+				if err != nil {
+					return n, err
+				}
+				if len(payload) > 0 {
+					c.replies = append(c.replies, c.options.subnegotiate(payload[0], payload[1:])...)
+				}
+				if n > 0 {
+					break
+				}
+				if err := c.flushReplies(); err != nil {
+					return n, err
+				}
+			} else if cmd == EOR && c.Mode == ReadLine && n > 0 {
+				// A record boundary is as good as a newline in line mode
+				break
+			}
+			// Other commands (NOP, GA, ...) carry no data and are dropped, but
+			// still fall through to the drain check so a prompt that ends in
+			// IAC GA is shown immediately
+		} else if b == 0 && c.afterCR {
+			// CR NUL is a bare carriage return (RFC 854); the NUL is only
+			// there to keep the CR from pairing with what follows
+			c.afterCR = false
+		} else {
+			p[n] = b
+			n++
+			c.afterCR = b == '\r' && !c.options.Remote(OptBinary)
+			if c.Mode == ReadChar || (c.Mode == ReadLine && b == '\n') {
+				break
+			}
+		}
+
+		// Every mode returns once the buffer is drained, so prompts without
+		// a trailing newline are never held back waiting for more data
+		if c.reader.Buffered() == 0 && n > 0 {
+			break
+		}
+	}
+	return n, nil
+}
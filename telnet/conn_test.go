@@ -0,0 +1,574 @@
+package telnet
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pipe returns a Conn with the default options and the server end of its link
+func pipe(t *testing.T) (*Conn, net.Conn) {
+	t.Helper()
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return NewConn(client, NewOptions()), server
+}
+
+// serve writes each chunk to the server end, then closes it. A write
+// returns once the client has read it, so a reply to one chunk is sent
+// before the next chunk (or the close) arrives.
+func serve(server net.Conn, chunks ...[]byte) {
+	go func() {
+		for _, c := range chunks {
+			server.Write(c)
+		}
+		server.Close()
+	}()
+}
+
+// replyTo writes input to the server end while the client reads, and
+// returns the first n bytes the client sends back
+func replyTo(t *testing.T, c *Conn, server net.Conn, input []byte, n int) []byte {
+	t.Helper()
+	go server.Write(input)
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			if _, err := c.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	reply := make([]byte, n)
+	if _, err := io.ReadFull(server, reply); err != nil {
+		t.Fatalf("reading reply: %v (got %v)", err, reply)
+	}
+	return reply
+}
+
+// readAll returns what each Read call produced until EOF
+func readAll(t *testing.T, c *Conn) []string {
+	t.Helper()
+	var reads []string
+	buf := make([]byte, 64)
+	for {
+		n, err := c.Read(buf)
+		if n > 0 {
+			reads = append(reads, string(buf[:n]))
+		}
+		if err == io.EOF || errors.Is(err, io.ErrClosedPipe) {
+			return reads
+		}
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+	}
+}
+
+func TestReadModes(t *testing.T) {
+	tests := []struct {
+		name  string
+		mode  ReadMode
+		input []byte
+		want  []string
+	}{
+		{"burst", ReadBurst, []byte("one\r\ntwo\r\nthr"), []string{"one\r\ntwo\r\nthr"}},
+		{"line", ReadLine, []byte("one\r\ntwo\r\nthr"), []string{"one\r\n", "two\r\n", "thr"}},
+		{"char", ReadChar, []byte("ab\n"), []string{"a", "b", "\n"}},
+		{"line ends at EOR", ReadLine, []byte("login:\xff\xefpw"), []string{"login:", "pw"}},
+		{"burst ignores EOR", ReadBurst, []byte("login:\xff\xefpw"), []string{"login:pw"}},
+		{"char drops commands", ReadChar, []byte("a\xff\xf1b"), []string{"a", "b"}},
+		{"escaped IAC is data", ReadLine, []byte("a\xff\xffb\n"), []string{"a\xffb\n"}},
+		{"CR NUL is a bare CR", ReadBurst, []byte("50%\r\x0060%\r\n"), []string{"50%\r60%\r\n"}},
+		{"NUL without CR is data", ReadBurst, []byte("a\x00b"), []string{"a\x00b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, server := pipe(t)
+			c.Mode = tt.mode
+			serve(server, tt.input)
+			if got := readAll(t, c); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("reads = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// Every mode hands back a partial line once nothing more is buffered, so
+// a prompt without a newline is shown right away
+func TestReadReturnsWhenDrained(t *testing.T) {
+	for _, mode := range []ReadMode{ReadBurst, ReadLine, ReadChar} {
+		c, server := pipe(t)
+		c.Mode = mode
+		go server.Write([]byte("$"))
+		buf := make([]byte, 64)
+		n, err := c.Read(buf)
+		if err != nil || string(buf[:n]) != "$" {
+			t.Errorf("mode %d: Read = %q, %v; want \"$\"", mode, buf[:n], err)
+		}
+	}
+}
+
+func TestCRNULSplitAcrossReads(t *testing.T) {
+	c, server := pipe(t)
+	serve(server, []byte("a\r"), []byte("\x00b"))
+	if got := strings.Join(readAll(t, c), ""); got != "a\rb" {
+		t.Errorf("data = %q, want \"a\\rb\"", got)
+	}
+}
+
+// In BINARY mode every byte is data, NULs after CR included
+func TestCRNULKeptInBinary(t *testing.T) {
+	c, server := pipe(t)
+	c.Options().Register(OptBinary, nil, true, true)
+	serve(server, []byte{IAC, WILL, OptBinary}, []byte("\r\x00z"))
+	go io.Copy(io.Discard, server) // The DO BINARY reply
+	if got := strings.Join(readAll(t, c), ""); got != "\r\x00z" {
+		t.Errorf("data = %q, want \"\\r\\x00z\"", got)
+	}
+}
+
+func TestAcceptsRemoteEOR(t *testing.T) {
+	c, server := pipe(t)
+	got := replyTo(t, c, server, []byte{IAC, WILL, OptEOR}, 3)
+	if want := []byte{IAC, DO, OptEOR}; !bytes.Equal(got, want) {
+		t.Errorf("reply = %v, want %v", got, want)
+	}
+}
+
+// readConn is a net.Conn that reads from r and discards writes
+type readConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c readConn) Read(p []byte) (int, error)  { return c.r.Read(p) }
+func (c readConn) Write(p []byte) (int, error) { return len(p), nil }
+
+func benchmarkRead(b *testing.B, mode ReadMode) {
+	var payload []byte
+	for len(payload) < 64<<10 {
+		payload = append(payload, "Router# show interfaces status\r\n"...)
+		payload = append(payload, IAC, GA)
+	}
+	buf := make([]byte, 32<<10)
+	b.SetBytes(int64(len(payload)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c := NewConn(readConn{r: bytes.NewReader(payload)}, NewOptions())
+		c.Mode = mode
+		for {
+			if _, err := c.Read(buf); err != nil {
+				break
+			}
+		}
+	}
+}
+
+func BenchmarkReadBurst(b *testing.B) { benchmarkRead(b, ReadBurst) }
+func BenchmarkReadLine(b *testing.B)  { benchmarkRead(b, ReadLine) }
+func BenchmarkReadChar(b *testing.B)  { benchmarkRead(b, ReadChar) }
+
+func TestNegotiationFlood(t *testing.T) {
+	c, server := pipe(t)
+	c.MaxNegotiations = 3
+	go io.Copy(io.Discard, server) // The DONT replies
+	var flood []byte
+	for i := 0; i < 10; i++ {
+		flood = append(flood, IAC, WILL, byte(100+i))
+	}
+	go server.Write(flood)
+	buf := make([]byte, 64)
+	var err error
+	for err == nil {
+		_, err = c.Read(buf)
+	}
+	if !errors.Is(err, ErrNegotiationFlood) {
+		t.Errorf("Read error = %v, want ErrNegotiationFlood", err)
+	}
+	if c.negotiations != 4 {
+		t.Errorf("stopped after %d negotiations, want 4", c.negotiations)
+	}
+}
+
+func TestNegotiationLimitNotReached(t *testing.T) {
+	c, server := pipe(t)
+	c.MaxNegotiations = 3
+	go io.Copy(io.Discard, server)
+	serve(server, []byte{IAC, WILL, OptSGA, IAC, DO, OptSGA, IAC, WILL, OptEcho}, []byte("ok"))
+	buf := make([]byte, 64)
+	var data []byte
+	for {
+		n, err := c.Read(buf)
+		data = append(data, buf[:n]...)
+		if err != nil {
+			if errors.Is(err, ErrNegotiationFlood) {
+				t.Fatal("three negotiations tripped a limit of three")
+			}
+			break
+		}
+	}
+	if string(data) != "ok" {
+		t.Errorf("data = %q, want \"ok\"", data)
+	}
+}
+
+// Each health-check probe gets a TIMING-MARK ack; a long idle session
+// collects far more of them than the limit allows for negotiation
+func TestTimingMarkAcksNotCounted(t *testing.T) {
+	c, server := pipe(t)
+	c.MaxNegotiations = 3
+	var acks []byte
+	for i := 0; i < 2000; i++ {
+		acks = append(acks, IAC, WILL, OptTimingMark, IAC, WONT, OptTimingMark)
+	}
+	serve(server, append(acks, "ok"...))
+	var data []byte
+	buf := make([]byte, 64)
+	for {
+		n, err := c.Read(buf)
+		data = append(data, buf[:n]...)
+		if err != nil {
+			if errors.Is(err, ErrNegotiationFlood) {
+				t.Fatal("TIMING-MARK acks tripped the negotiation limit")
+			}
+			break
+		}
+	}
+	if string(data) != "ok" {
+		t.Errorf("data = %q, want \"ok\"", data)
+	}
+}
+
+// Negotiation in the middle of a banner must neither eat banner text nor
+// hold it back behind the reply
+func TestNegotiationInsideBanner(t *testing.T) {
+	c, server := pipe(t)
+	input := append([]byte("Welcome\r\n"), IAC, DO, OptEcho)
+	input = append(input, "more-banner\r\n"...)
+	go server.Write(input)
+
+	// The first read returns the text ahead of the command right away
+	buf := make([]byte, 64)
+	n, err := c.Read(buf)
+	if err != nil || string(buf[:n]) != "Welcome\r\n" {
+		t.Fatalf("first Read = %q, %v; want the banner's first line", buf[:n], err)
+	}
+	reads := make(chan string, 1)
+	go func() {
+		n, _ := c.Read(buf)
+		reads <- string(buf[:n])
+	}()
+	reply := make([]byte, 3)
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(server, reply); err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{IAC, WONT, OptEcho}; !bytes.Equal(reply, want) {
+		t.Errorf("reply = %v, want %v (IAC WONT ECHO)", reply, want)
+	}
+	if got := <-reads; got != "more-banner\r\n" {
+		t.Errorf("second Read = %q, want the rest of the banner", got)
+	}
+}
+
+func TestEXOPLNegotiation(t *testing.T) {
+	for _, tt := range []struct {
+		cmd, reply byte
+	}{{DO, WONT}, {WILL, DONT}} {
+		c, server := pipe(t)
+		got := replyTo(t, c, server, []byte{IAC, tt.cmd, OptEXOPL}, 3)
+		if want := []byte{IAC, tt.reply, OptEXOPL}; !bytes.Equal(got, want) {
+			t.Errorf("reply to %d EXOPL = %v, want %v", tt.cmd, got, want)
+		}
+	}
+}
+
+// sbRecorder keeps every payload it is handed
+type sbRecorder struct{ payloads [][]byte }
+
+func (r *sbRecorder) Enabled(local bool) []byte { return nil }
+func (r *sbRecorder) Subnegotiate(data []byte) []byte {
+	r.payloads = append(r.payloads, append([]byte(nil), data...))
+	return nil
+}
+
+// The option byte of an EXOPL subnegotiation is 255 but not an IAC, so
+// it must not pair with what follows it
+func TestEXOPLSubnegotiation(t *testing.T) {
+	tests := []struct {
+		name    string
+		sb      []byte
+		payload []byte
+	}{
+		{"single", []byte{IAC, SB, OptEXOPL, DO, 1, IAC, SE}, []byte{DO, 1}},
+		{"doubled", []byte{IAC, SB, IAC, IAC, DO, 1, IAC, SE}, []byte{DO, 1}},
+		{"empty", []byte{IAC, SB, OptEXOPL, IAC, SE}, []byte{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, server := pipe(t)
+			rec := &sbRecorder{}
+			c.Options().Register(OptEXOPL, rec, false, true)
+			go io.Copy(io.Discard, server) // The DO EXOPL reply
+			// Separate writes, so the reply goes out before the server hangs up
+			serve(server, []byte{IAC, WILL, OptEXOPL}, append(tt.sb, "after"...))
+			if got := strings.Join(readAll(t, c), ""); got != "after" {
+				t.Errorf("data = %q, want \"after\"", got)
+			}
+			want := tt.sb[len(tt.sb)-4 : len(tt.sb)-2]
+			if tt.name == "empty" {
+				want = []byte{}
+			}
+			if len(rec.payloads) != 1 || !bytes.Equal(rec.payloads[0], want) {
+				t.Errorf("payloads = %v, want [%v]", rec.payloads, want)
+			}
+		})
+	}
+}
+
+func TestMaxSB(t *testing.T) {
+	sb := func(n int) []byte {
+		f := []byte{IAC, SB, OptTermType}
+		f = append(f, bytes.Repeat([]byte{'x'}, n)...)
+		return append(f, IAC, SE)
+	}
+	tests := []struct {
+		name     string
+		max      int
+		size     int
+		kept     bool
+		reported bool
+	}{
+		{"within the cap", 8, 4, true, false},
+		{"at the cap", 8, 8, true, false},
+		{"over the cap", 8, 9, false, true},
+		{"far over the cap", 8, 4096, false, true},
+		{"unlimited", 0, 4096, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, server := pipe(t)
+			c.MaxSB = tt.max
+			rec := &sbRecorder{}
+			c.Options().Register(OptTermType, rec, true, false)
+			var errs []string
+			c.Options().OnProtocolError = func(opt byte, detail string) {
+				errs = append(errs, detail)
+			}
+			go io.Copy(io.Discard, server) // The WILL TERMINAL-TYPE reply
+			// A small subnegotiation after the big one still gets through
+			input := append(sb(tt.size), "after"...)
+			serve(server, []byte{IAC, DO, OptTermType}, append(input, sb(2)...))
+			if got := strings.Join(readAll(t, c), ""); got != "after" {
+				t.Errorf("data = %q, want \"after\"", got)
+			}
+			want := 1
+			if tt.kept {
+				want = 2
+			}
+			if len(rec.payloads) != want || len(rec.payloads[len(rec.payloads)-1]) != 2 {
+				t.Errorf("got %d payloads, want %d ending with the small one", len(rec.payloads), want)
+			}
+			if tt.kept && len(rec.payloads[0]) != tt.size {
+				t.Errorf("first payload is %d bytes, want %d", len(rec.payloads[0]), tt.size)
+			}
+			if reported := len(errs) > 0; reported != tt.reported {
+				t.Errorf("protocol errors = %q, reported want %v", errs, tt.reported)
+			}
+		})
+	}
+}
+
+func TestWriteDoublesIAC(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"plain", "show run\r", "show run\r"},
+		{"one IAC", "a\xffb", "a\xff\xffb"},
+		{"IAC pair", "\xff\xff", "\xff\xff\xff\xff"},
+		{"command bytes", "\xff\xf3", "\xff\xff\xf3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, server := pipe(t)
+			got := make(chan string, 1)
+			go func() {
+				b := make([]byte, len(tt.want)+len(tt.data))
+				io.ReadFull(server, b)
+				got <- string(b)
+			}()
+			// Write counts the caller's bytes, not the doubled ones
+			n, err := c.Write([]byte(tt.data))
+			if err != nil || n != len(tt.data) {
+				t.Errorf("Write = %d, %v; want %d, nil", n, err, len(tt.data))
+			}
+			// WriteRaw leaves commands alone
+			if _, err := c.WriteRaw([]byte(tt.data)); err != nil {
+				t.Fatal(err)
+			}
+			if sent, want := <-got, tt.want+tt.data; sent != want {
+				t.Errorf("sent %q, want %q", sent, want)
+			}
+		})
+	}
+}
+
+// step is one option command from the peer and our expected reply
+type step struct {
+	cmd, opt byte
+	reply    []byte
+}
+
+func TestNegotiationReplies(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"DO unsupported", []step{{DO, OptNAWS, []byte{IAC, WONT, OptNAWS}}}},
+		{"WILL unsupported", []step{{WILL, OptStatus, []byte{IAC, DONT, OptStatus}}}},
+		{"refusals repeat", []step{
+			{DO, OptNAWS, []byte{IAC, WONT, OptNAWS}},
+			{DO, OptNAWS, []byte{IAC, WONT, OptNAWS}},
+		}},
+		{"WILL SGA", []step{
+			{WILL, OptSGA, []byte{IAC, DO, OptSGA}},
+			{WILL, OptSGA, nil}, // Already on: acknowledging again would loop
+		}},
+		{"DO SGA", []step{
+			{DO, OptSGA, []byte{IAC, WILL, OptSGA}},
+			{DO, OptSGA, nil},
+		}},
+		{"echo on and off", []step{
+			{WILL, OptEcho, []byte{IAC, DO, OptEcho}},
+			{WONT, OptEcho, []byte{IAC, DONT, OptEcho}},
+			{WONT, OptEcho, nil},
+		}},
+		{"off is already off", []step{{WONT, OptSGA, nil}, {DONT, OptSGA, nil}}},
+		{"timing mark acknowledged", []step{{WILL, OptTimingMark, nil}, {WONT, OptTimingMark, nil}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := NewOptions()
+			for i, c := range tt.steps {
+				if got := options.answer(c.cmd, c.opt); !bytes.Equal(got, c.reply) {
+					t.Errorf("command %d (%d %d): reply = %v, want %v", i, c.cmd, c.opt, got, c.reply)
+				}
+			}
+		})
+	}
+}
+
+func TestRequestRemote(t *testing.T) {
+	options := NewOptions()
+	if got, want := options.RequestRemote(OptEcho, true), []byte{IAC, DO, OptEcho}; !bytes.Equal(got, want) {
+		t.Errorf("RequestRemote = %v, want %v", got, want)
+	}
+	if got := options.RequestRemote(OptEcho, true); got != nil {
+		t.Errorf("second RequestRemote = %v, want nil", got)
+	}
+	// The peer's WILL acknowledges our DO, so it goes unanswered
+	if got := options.answer(WILL, OptEcho); got != nil {
+		t.Errorf("reply to the acknowledgement = %v, want nil", got)
+	}
+	if !options.Remote(OptEcho) {
+		t.Error("ECHO not on after the peer agreed")
+	}
+}
+
+func TestOfferLocal(t *testing.T) {
+	options := NewOptions()
+	naws := &sbRecorder{}
+	options.Register(OptNAWS, naws, true, false)
+	if got := options.OfferLocal(OptStatus); got != nil {
+		t.Errorf("OfferLocal of an unsupported option = %v, want nil", got)
+	}
+	if got, want := options.OfferLocal(OptNAWS), []byte{IAC, WILL, OptNAWS}; !bytes.Equal(got, want) {
+		t.Errorf("OfferLocal = %v, want %v", got, want)
+	}
+	if got := options.OfferLocal(OptNAWS); got != nil {
+		t.Errorf("second OfferLocal = %v, want nil", got)
+	}
+	if options.Local(OptNAWS) {
+		t.Error("NAWS on before the peer agreed")
+	}
+	// DO acknowledges the offer: no WILL back, but the option is on
+	if got := options.answer(DO, OptNAWS); got != nil {
+		t.Errorf("reply to the acknowledgement = %v, want nil", got)
+	}
+	if !options.Local(OptNAWS) {
+		t.Error("NAWS not on after the peer agreed")
+	}
+	if got := options.OfferLocal(OptNAWS); got != nil {
+		t.Errorf("OfferLocal of an active option = %v, want nil", got)
+	}
+}
+
+// Replies travel back over the connection once a Read reaches them
+func TestNegotiationRepliesSent(t *testing.T) {
+	c, server := pipe(t)
+	got := replyTo(t, c, server, []byte{IAC, WILL, OptSGA, IAC, DO, OptNAWS, IAC, DO, OptSGA}, 9)
+	want := []byte{IAC, DO, OptSGA, IAC, WONT, OptNAWS, IAC, WILL, OptSGA}
+	if !bytes.Equal(got, want) {
+		t.Errorf("replies = %v, want %v", got, want)
+	}
+}
+
+func TestSubnegotiationHandler(t *testing.T) {
+	c, server := pipe(t)
+	var payload []byte
+	c.Options().Register(OptTermType, SubnegotiationFunc(func(data []byte) []byte {
+		payload = append([]byte(nil), data...)
+		return FrameSB(OptTermType, []byte{0, 'x', IAC})
+	}), true, false)
+	input := []byte{IAC, DO, OptTermType, IAC, SB, OptTermType, 1, IAC, IAC, 2, IAC, SE}
+	got := replyTo(t, c, server, input, 3+9)
+	// The handler sees the IAC once; its reply has it doubled again
+	want := []byte{IAC, WILL, OptTermType, IAC, SB, OptTermType, 0, 'x', IAC, IAC, IAC, SE}
+	if !bytes.Equal(got, want) {
+		t.Errorf("replies = %v, want %v", got, want)
+	}
+	if !bytes.Equal(payload, []byte{1, IAC, 2}) {
+		t.Errorf("handler got %v, want [1 255 2]", payload)
+	}
+}
+
+// Subnegotiations for options that aren't on are dropped unseen
+func TestSubnegotiationInactive(t *testing.T) {
+	c, server := pipe(t)
+	rec := &sbRecorder{}
+	c.Options().Register(OptTermType, rec, true, false)
+	serve(server, []byte{IAC, SB, OptTermType, 1, IAC, SE, 'z'})
+	if got := strings.Join(readAll(t, c), ""); got != "z" {
+		t.Errorf("data = %q, want \"z\"", got)
+	}
+	if len(rec.payloads) != 0 {
+		t.Errorf("handler got %v, want nothing", rec.payloads)
+	}
+}
+
+func TestFrameSB(t *testing.T) {
+	tests := []struct {
+		payload, want []byte
+	}{
+		{nil, []byte{IAC, SB, OptNAWS, IAC, SE}},
+		{[]byte{0, 80, 0, 24}, []byte{IAC, SB, OptNAWS, 0, 80, 0, 24, IAC, SE}},
+		{[]byte{IAC, SE}, []byte{IAC, SB, OptNAWS, IAC, IAC, SE, IAC, SE}},
+	}
+	for _, tt := range tests {
+		if got := FrameSB(OptNAWS, tt.payload); !bytes.Equal(got, tt.want) {
+			t.Errorf("FrameSB(%v) = %v, want %v", tt.payload, got, tt.want)
+		}
+	}
+}
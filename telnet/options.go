@@ -0,0 +1,263 @@
+package telnet
+
+import "sync"
+
+// Options is the central record of which options are active. Local
+// options are ones we perform (we said WILL), remote options are ones the
+// peer performs (we said DO).
+type Options struct {
+	mu     sync.Mutex
+	local  [256]bool
+	remote [256]bool
+
+	// Options we are willing to enable when asked
+	supportLocal  [256]bool
+	supportRemote [256]bool
+
+	handlers [256]Handler
+
+	// Local options we offered with WILL and are waiting for DO/DONT on
+	offered [256]bool
+	// Remote options the peer said WONT to, most recently
+	refused [256]bool
+
+	// OnChange, if set, is called after an option turns on or off. It runs
+	// on the goroutine reading the connection, without any lock held.
+	OnChange func(opt byte, local, on bool)
+	// OnProtocolError, if set, is told about malformed input that was dropped
+	OnProtocolError func(opt byte, detail string)
+}
+
+// Handler implements the subnegotiation side of an option
+type Handler interface {
+	// Enabled is called when the option becomes active in the given
+	// direction and may return bytes (usually an SB) to send right away
+	Enabled(local bool) []byte
+	// Subnegotiate handles an SB payload (after the option byte) and
+	// returns any reply
+	Subnegotiate(data []byte) []byte
+}
+
+// SubnegotiationFunc adapts a function to a Handler that only answers SB
+type SubnegotiationFunc func(data []byte) []byte
+
+func (f SubnegotiationFunc) Enabled(local bool) []byte       { return nil }
+func (f SubnegotiationFunc) Subnegotiate(data []byte) []byte { return f(data) }
+
+// NewOptions returns a table that agrees to SGA in both directions, to
+// the peer echoing and to the peer marking records with IAC EOR (which
+// ReadLine treats as a line end). Everything else is refused until registered.
+func NewOptions() *Options {
+	t := &Options{}
+	t.supportLocal[OptSGA] = true
+	t.supportRemote[OptSGA] = true
+	t.supportRemote[OptEcho] = true
+	t.supportRemote[OptEOR] = true
+	return t
+}
+
+// Register installs a handler for opt (which may be nil) and marks which
+// directions we support
+func (t *Options) Register(opt byte, h Handler, local, remote bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.handlers[opt] = h
+	t.supportLocal[opt] = local
+	t.supportRemote[opt] = remote
+}
+
+// handler returns the handler registered for opt, if any
+func (t *Options) handler(opt byte) Handler {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.handlers[opt]
+}
+
+// subnegotiate routes an SB payload to the option's handler, if the option is active
+func (t *Options) subnegotiate(opt byte, data []byte) []byte {
+	h := t.handler(opt)
+	if h == nil || (!t.Local(opt) && !t.Remote(opt)) {
+		return nil
+	}
+	return h.Subnegotiate(data)
+}
+
+// enabled builds the reply for an option that just became active
+func (t *Options) enabled(reply []byte, opt byte, local bool) []byte {
+	if h := t.handler(opt); h != nil {
+		reply = append(reply, h.Enabled(local)...)
+	}
+	return reply
+}
+
+// Active returns the names of the options currently enabled on each side
+func (t *Options) Active() (local, remote []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for opt := 0; opt < 256; opt++ {
+		if t.local[opt] {
+			local = append(local, OptionName(byte(opt)))
+		}
+		if t.remote[opt] {
+			remote = append(remote, OptionName(byte(opt)))
+		}
+	}
+	return local, remote
+}
+
+// Local reports whether we are currently performing opt
+func (t *Options) Local(opt byte) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.local[opt]
+}
+
+// Remote reports whether the peer is currently performing opt
+func (t *Options) Remote(opt byte) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.remote[opt]
+}
+
+// LocalEcho reports whether we should echo typed keys ourselves: the
+// peer has refused to echo. Peers that never mention ECHO keep the
+// raw-mode default of no local echo, as raw TCP services usually echo
+// (or not) without negotiating.
+func (t *Options) LocalEcho() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.refused[OptEcho] && !t.remote[OptEcho]
+}
+
+// Missing returns the names of opts that are active in neither direction
+func (t *Options) Missing(opts []byte) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var missing []string
+	for _, opt := range opts {
+		if !t.local[opt] && !t.remote[opt] {
+			missing = append(missing, OptionName(opt))
+		}
+	}
+	return missing
+}
+
+// set records a state change and reports whether anything changed
+func (t *Options) set(opt byte, local, on bool) bool {
+	t.mu.Lock()
+	state := &t.remote
+	if local {
+		state = &t.local
+	}
+	changed := state[opt] != on
+	state[opt] = on
+	onChange := t.OnChange
+	t.mu.Unlock()
+
+	if changed && onChange != nil {
+		onChange(opt, local, on)
+	}
+	return changed
+}
+
+// answer returns the reply to an option command, or nil if none is due.
+// Replies are only sent when our state actually changes (or when refusing),
+// so we never re-acknowledge an option and can't get into a loop.
+func (t *Options) answer(cmd, opt byte) []byte {
+	// WILL/WONT TIMING-MARK acknowledges a mark we asked for; it never
+	// turns anything on, so there is nothing to answer
+	if opt == OptTimingMark && (cmd == WILL || cmd == WONT) {
+		return nil
+	}
+	t.mu.Lock()
+	supportLocal, supportRemote := t.supportLocal[opt], t.supportRemote[opt]
+	t.mu.Unlock()
+
+	switch cmd {
+	case DO:
+		if !supportLocal {
+			return []byte{IAC, WONT, opt}
+		}
+		if t.set(opt, true, true) {
+			// DO acknowledging our own offer needs no WILL back
+			var reply []byte
+			if !t.takeOffer(opt) {
+				reply = []byte{IAC, WILL, opt}
+			}
+			return t.enabled(reply, opt, true)
+		}
+		t.takeOffer(opt)
+	case DONT:
+		t.takeOffer(opt)
+		if t.set(opt, true, false) {
+			return []byte{IAC, WONT, opt}
+		}
+	case WILL:
+		if !supportRemote {
+			return []byte{IAC, DONT, opt}
+		}
+		t.setRefused(opt, false)
+		if t.set(opt, false, true) {
+			return t.enabled([]byte{IAC, DO, opt}, opt, false)
+		}
+	case WONT:
+		t.setRefused(opt, true)
+		if t.set(opt, false, false) {
+			return []byte{IAC, DONT, opt}
+		}
+	}
+	return nil
+}
+
+// RequestRemote asks the peer to enable or disable one of its options.
+// Our state changes first, so the peer's acknowledgement is not a change
+// and goes unanswered. It returns nil if the option is already in that state.
+func (t *Options) RequestRemote(opt byte, on bool) []byte {
+	if !t.set(opt, false, on) {
+		return nil
+	}
+	if on {
+		return []byte{IAC, DO, opt}
+	}
+	return []byte{IAC, DONT, opt}
+}
+
+// OfferLocal announces WILL for an option we support before the peer
+// asks. Unlike RequestRemote our state only changes once the peer agrees,
+// because enabling an option such as NAWS sends data the peer must be
+// ready for. It returns nil if the option is already on or offered.
+func (t *Options) OfferLocal(opt byte) []byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.supportLocal[opt] || t.local[opt] || t.offered[opt] {
+		return nil
+	}
+	t.offered[opt] = true
+	return []byte{IAC, WILL, opt}
+}
+
+// takeOffer clears a pending offer and reports whether there was one
+func (t *Options) takeOffer(opt byte) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	offered := t.offered[opt]
+	t.offered[opt] = false
+	return offered
+}
+
+// setRefused records whether the peer's last word on opt was WONT
+func (t *Options) setRefused(opt byte, refused bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.refused[opt] = refused
+}
+
+// protocolError reports dropped input through OnProtocolError
+func (t *Options) protocolError(opt byte, detail string) {
+	t.mu.Lock()
+	report := t.OnProtocolError
+	t.mu.Unlock()
+	if report != nil {
+		report(opt, detail)
+	}
+}
@@ -0,0 +1,105 @@
+// Package telnet implements the client side of the telnet protocol (RFC 854)
+// over any net.Conn: the IAC state machine, option negotiation and
+// subnegotiation. Conn strips protocol commands from what it reads, answers
+// them through an Options table, and escapes IAC bytes in what it writes.
+package telnet
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Telnet commands
+const (
+	IAC  = 255 // Interpret As Command
+	DONT = 254
+	DO   = 253
+	WONT = 252
+	WILL = 251
+	SB   = 250 // Subnegotiation Begin
+	GA   = 249 // Go Ahead
+	EL   = 248 // Erase Line
+	EC   = 247 // Erase Character
+	AYT  = 246 // Are You There
+	AO   = 245 // Abort Output
+	IP   = 244 // Interrupt Process
+	BRK  = 243 // Break
+	DM   = 242 // Data Mark
+	NOP  = 241 // No Operation
+	SE   = 240 // Subnegotiation End
+	EOR  = 239 // End of Record
+)
+
+// Telnet option codes we know by name
+const (
+	OptBinary     = 0
+	OptEcho       = 1
+	OptSGA        = 3 // Suppress Go Ahead
+	OptStatus     = 5
+	OptTimingMark = 6
+	OptTermType   = 24
+	OptEOR        = 25
+	OptNAWS       = 31 // Negotiate About Window Size
+	OptLinemode   = 34
+	OptNewEnviron = 39
+	OptCharset    = 42
+	OptMSDP       = 69
+	OptMSSP       = 70
+	OptMCCP2      = 86
+	OptGMCP       = 201
+	OptEXOPL      = 255 // Extended Options List
+)
+
+var optionNames = map[byte]string{
+	OptBinary:     "BINARY",
+	OptEcho:       "ECHO",
+	OptSGA:        "SGA",
+	OptStatus:     "STATUS",
+	OptTimingMark: "TIMING-MARK",
+	OptTermType:   "TERMINAL-TYPE",
+	OptEOR:        "EOR",
+	OptNAWS:       "NAWS",
+	OptLinemode:   "LINEMODE",
+	OptNewEnviron: "NEW-ENVIRON",
+	OptCharset:    "CHARSET",
+	OptMSDP:       "MSDP",
+	OptMSSP:       "MSSP",
+	OptMCCP2:      "MCCP2",
+	OptGMCP:       "GMCP",
+	OptEXOPL:      "EXOPL",
+}
+
+// OptionName returns the symbolic name of an option, or OPT-<n> if unknown
+func OptionName(opt byte) string {
+	if name, ok := optionNames[opt]; ok {
+		return name
+	}
+	return fmt.Sprintf("OPT-%d", opt)
+}
+
+// ParseOption accepts an option name (case-insensitive) or its decimal code
+func ParseOption(s string) (byte, error) {
+	name := strings.ToUpper(strings.TrimSpace(s))
+	for opt, n := range optionNames {
+		if n == name {
+			return opt, nil
+		}
+	}
+	if v, err := strconv.ParseUint(name, 10, 8); err == nil {
+		return byte(v), nil
+	}
+	return 0, fmt.Errorf("unknown telnet option %q", s)
+}
+
+// FrameSB wraps an SB payload for opt, doubling any IAC bytes inside it
+func FrameSB(opt byte, payload []byte) []byte {
+	out := []byte{IAC, SB, opt}
+	for _, b := range payload {
+		out = append(out, b)
+		if b == IAC {
+			out = append(out, IAC)
+		}
+	}
+	return append(out, IAC, SE)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"runtime"
+
+	"better-telnet/telnet"
+)
+
+// TERMINAL-TYPE subnegotiation commands (RFC 1091)
+const (
+	ttypeIS   = 0
+	ttypeSend = 1
+)
+
+// TerminalType reports our terminal type to the server
+type TerminalType struct {
+	name    string
+	options *OptionTable
+}
+
+// NewTerminalType takes the name to report from $TERM. Windows consoles
+// don't set it, but Windows Terminal and modern conhost speak xterm.
+func NewTerminalType(options *OptionTable) *TerminalType {
+	name := os.Getenv("TERM")
+	if name == "" {
+		name = "xterm"
+		if runtime.GOOS == "windows" {
+			name = "xterm-256color"
+		}
+	}
+	return &TerminalType{name: name, options: options}
+}
+
+// Enabled sends nothing; the server asks for the type with SB SEND
+func (t *TerminalType) Enabled(local bool) []byte {
+	return nil
+}
+
+// Subnegotiate answers SEND with IS <name>. We only have one name, so
+// every SEND gets the same answer, which tells the server the list ends there.
+func (t *TerminalType) Subnegotiate(data []byte) []byte {
+	if len(data) == 0 || data[0] != ttypeSend {
+		return nil
+	}
+	t.options.recordTerminalType(t.name)
+	return telnet.FrameSB(telnet.OptTermType, append([]byte{ttypeIS}, t.name...))
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrFingerprintChanged is returned when a server presents a different
+// certificate than the one pinned in the known-hosts file
+var ErrFingerprintChanged = errors.New("server certificate changed")
+
+// defaultKnownHostsFile is where pinned TLS fingerprints live by default
+func defaultKnownHostsFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".btel_known_hosts"
+	}
+	return filepath.Join(home, ".btel_known_hosts")
+}
+
+// startTLS runs the TLS handshake over conn. Telnet-over-TLS devices almost
+// always carry self-signed certificates, so by default trust works like SSH
+// host keys: the first certificate seen for host:port is pinned and later
+// connections must present the same one. With -tls-ca the chain is
+// verified against that CA instead, and -tls-skip-verify checks nothing.
+func startTLS(conn net.Conn, config Config) (net.Conn, error) {
+	tlsConfig, err := newTLSConfig(config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tlsConn := tls.Client(conn, tlsConfig)
+	tlsConn.SetDeadline(time.Now().Add(10 * time.Second))
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
+// newTLSConfig builds the client TLS settings from the -tls flags
+func newTLSConfig(config Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{ServerName: config.Host}
+	if config.TLSCert != "" {
+		key := config.TLSKey
+		if key == "" {
+			key = config.TLSCert // A single PEM file holding both
+		}
+		cert, err := tls.LoadX509KeyPair(config.TLSCert, key)
+		if err != nil {
+			return nil, fmt.Errorf("client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	switch {
+	case config.TLSSkipVerify:
+		fmt.Fprintf(os.Stderr, "[*] %s\r\n", tr("TLS certificate verification is disabled (-tls-skip-verify)"))
+		tlsConfig.InsecureSkipVerify = true
+	case config.TLSCA != "":
+		pem, err := os.ReadFile(config.TLSCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no PEM certificates found", config.TLSCA)
+		}
+		tlsConfig.RootCAs = pool
+	default:
+		hosts, err := loadKnownHosts(config.TLSKnownHosts)
+		if err != nil {
+			return nil, err
+		}
+		addr := net.JoinHostPort(config.Host, config.Port)
+		// The pin check below replaces chain verification
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("server sent no certificate")
+			}
+			return hosts.check(addr, fingerprint(cs.PeerCertificates[0].Raw), config.TLSAcceptNew)
+		}
+	}
+	return tlsConfig, nil
+}
+
+// fingerprint returns the SHA-256 fingerprint of a DER certificate
+func fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// knownHosts is a file of "host:port fingerprint" lines
+type knownHosts struct {
+	path    string
+	entries map[string]string
+	order   []string // Keeps the file stable when it is rewritten
+}
+
+// loadKnownHosts reads path; a missing file is an empty list
+func loadKnownHosts(path string) (*knownHosts, error) {
+	k := &knownHosts{path: path, entries: map[string]string{}}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return k, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || !strings.HasPrefix(fields[1], "sha256:") {
+			return nil, fmt.Errorf("%s:%d: expected \"host:port sha256:<hex>\"", path, lineNo)
+		}
+		if _, seen := k.entries[fields[0]]; !seen {
+			k.order = append(k.order, fields[0])
+		}
+		k.entries[fields[0]] = fields[1]
+	}
+	return k, scanner.Err()
+}
+
+// check compares a server's fingerprint with the pinned one, pinning it on
+// first use. A changed certificate is refused unless acceptNew is set, in
+// which case the new fingerprint replaces the old.
+func (k *knownHosts) check(addr, fp string, acceptNew bool) error {
+	pinned, ok := k.entries[addr]
+	switch {
+	case ok && pinned == fp:
+		return nil
+	case !ok:
+		fmt.Fprintf(os.Stderr, "[*] %s\r\n", tr("New TLS certificate for %s (%s), saved to %s", addr, fp, k.path))
+	case !acceptNew:
+		fmt.Fprintf(os.Stderr, "[-] @@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@\r\n")
+		fmt.Fprintf(os.Stderr, "[-] %s\r\n", tr("WARNING: THE TLS CERTIFICATE OF %s HAS CHANGED!", addr))
+		fmt.Fprintf(os.Stderr, "[-] %s\r\n", tr("Someone could be intercepting the connection, or the device was re-keyed."))
+		fmt.Fprintf(os.Stderr, "[-] %s\r\n", tr("Pinned:    %s", pinned))
+		fmt.Fprintf(os.Stderr, "[-] %s\r\n", tr("Presented: %s", fp))
+		fmt.Fprintf(os.Stderr, "[-] %s\r\n", tr("Pass -tls-accept-new to trust the new certificate (known hosts: %s).", k.path))
+		fmt.Fprintf(os.Stderr, "[-] @@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@\r\n")
+		return ErrFingerprintChanged
+	default:
+		fmt.Fprintf(os.Stderr, "[*] %s\r\n", tr("Replacing pinned TLS certificate for %s with %s", addr, fp))
+	}
+
+	if !ok {
+		k.order = append(k.order, addr)
+	}
+	k.entries[addr] = fp
+	return k.save()
+}
+
+// save rewrites the known-hosts file
+func (k *knownHosts) save() error {
+	var b strings.Builder
+	for _, addr := range k.order {
+		fmt.Fprintf(&b, "%s %s\n", addr, k.entries[addr])
+	}
+	if err := os.MkdirAll(filepath.Dir(k.path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(k.path, []byte(b.String()), 0o600)
+}
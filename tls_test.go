@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	fpA = "sha256:aaaa"
+	fpB = "sha256:bbbb"
+)
+
+// knownHostsFile writes content to a fresh known-hosts file and loads it
+func knownHostsFile(t *testing.T, content string) *knownHosts {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	if content != "" {
+		os.WriteFile(path, []byte(content), 0600)
+	}
+	k, err := loadKnownHosts(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+// reload reads the file back, as the next run would
+func reload(t *testing.T, k *knownHosts) map[string]string {
+	t.Helper()
+	again, err := loadKnownHosts(k.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return again.entries
+}
+
+func TestKnownHostsFirstUse(t *testing.T) {
+	k := knownHostsFile(t, "")
+	if err := k.check("switch:992", fpA, false); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if got := reload(t, k)["switch:992"]; got != fpA {
+		t.Errorf("pinned %q, want %q", got, fpA)
+	}
+}
+
+func TestKnownHostsMatch(t *testing.T) {
+	k := knownHostsFile(t, "switch:992 "+fpA+"\n")
+	if err := k.check("switch:992", fpA, false); err != nil {
+		t.Errorf("matching fingerprint: %v", err)
+	}
+}
+
+func TestKnownHostsMismatch(t *testing.T) {
+	k := knownHostsFile(t, "switch:992 "+fpA+"\nrouter:992 "+fpB+"\n")
+	if err := k.check("switch:992", fpB, false); !errors.Is(err, ErrFingerprintChanged) {
+		t.Errorf("changed fingerprint: %v, want ErrFingerprintChanged", err)
+	}
+	if got := reload(t, k)["switch:992"]; got != fpA {
+		t.Errorf("refused certificate replaced the pin: %q", got)
+	}
+
+	// -tls-accept-new trusts the new one and keeps the other entries
+	if err := k.check("switch:992", fpB, true); err != nil {
+		t.Fatalf("accept new: %v", err)
+	}
+	entries := reload(t, k)
+	if entries["switch:992"] != fpB || entries["router:992"] != fpB {
+		t.Errorf("entries after accept = %v", entries)
+	}
+}
+
+func TestKnownHostsBadLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	os.WriteFile(path, []byte("# pins\nswitch:992\n"), 0600)
+	if _, err := loadKnownHosts(path); err == nil || !strings.Contains(err.Error(), ":2:") {
+		t.Errorf("error = %v, want one for line 2", err)
+	}
+}
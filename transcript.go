@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ansiPalette holds the 16 basic colors, xterm defaults
+var ansiPalette = [16]string{
+	"#000000", "#cd0000", "#00cd00", "#cdcd00", "#0000ee", "#cd00cd", "#00cdcd", "#e5e5e5",
+	"#7f7f7f", "#ff0000", "#00ff00", "#ffff00", "#5c5cff", "#ff00ff", "#00ffff", "#ffffff",
+}
+
+// xtermColor returns the CSS color for a 256-color palette index
+func xtermColor(n int) string {
+	switch {
+	case n < 16:
+		return ansiPalette[n]
+	case n < 232:
+		n -= 16
+		level := func(v int) int {
+			if v == 0 {
+				return 0
+			}
+			return 55 + v*40
+		}
+		return fmt.Sprintf("#%02x%02x%02x", level(n/36), level(n/6%6), level(n%6))
+	default:
+		g := 8 + (n-232)*10
+		return fmt.Sprintf("#%02x%02x%02x", g, g, g)
+	}
+}
+
+// sgrState is the text style selected by SGR escapes
+type sgrState struct {
+	fg, bg                   string // CSS colors, empty for the default
+	bold, dim, italic, under bool
+	inverse                  bool
+}
+
+// css renders the style as an inline style attribute value
+func (s sgrState) css() string {
+	fg, bg := s.fg, s.bg
+	if s.inverse {
+		fg, bg = bg, fg
+		if fg == "" {
+			fg = "#1e1e1e"
+		}
+		if bg == "" {
+			bg = "#d4d4d4"
+		}
+	}
+	var parts []string
+	if fg != "" {
+		parts = append(parts, "color:"+fg)
+	}
+	if bg != "" {
+		parts = append(parts, "background:"+bg)
+	}
+	if s.bold {
+		parts = append(parts, "font-weight:bold")
+	}
+	if s.dim {
+		parts = append(parts, "opacity:.7")
+	}
+	if s.italic {
+		parts = append(parts, "font-style:italic")
+	}
+	if s.under {
+		parts = append(parts, "text-decoration:underline")
+	}
+	return strings.Join(parts, ";")
+}
+
+// apply updates the state from the parameters of one SGR sequence
+func (s *sgrState) apply(params string) {
+	if params == "" {
+		params = "0"
+	}
+	var codes []int
+	for _, p := range strings.FieldsFunc(params, func(r rune) bool { return r == ';' || r == ':' }) {
+		n, _ := strconv.Atoi(p)
+		codes = append(codes, n)
+	}
+	for i := 0; i < len(codes); i++ {
+		switch c := codes[i]; {
+		case c == 0:
+			*s = sgrState{}
+		case c == 1:
+			s.bold = true
+		case c == 2:
+			s.dim = true
+		case c == 3:
+			s.italic = true
+		case c == 4:
+			s.under = true
+		case c == 7:
+			s.inverse = true
+		case c == 22:
+			s.bold, s.dim = false, false
+		case c == 23:
+			s.italic = false
+		case c == 24:
+			s.under = false
+		case c == 27:
+			s.inverse = false
+		case c >= 30 && c <= 37:
+			s.fg = ansiPalette[c-30]
+		case c >= 40 && c <= 47:
+			s.bg = ansiPalette[c-40]
+		case c >= 90 && c <= 97:
+			s.fg = ansiPalette[c-90+8]
+		case c >= 100 && c <= 107:
+			s.bg = ansiPalette[c-100+8]
+		case c == 39:
+			s.fg = ""
+		case c == 49:
+			s.bg = ""
+		case c == 38 || c == 48:
+			var color string
+			if i+2 < len(codes) && codes[i+1] == 5 {
+				color = xtermColor(codes[i+2] & 0xff)
+				i += 2
+			} else if i+4 < len(codes) && codes[i+1] == 2 {
+				color = fmt.Sprintf("#%02x%02x%02x", codes[i+2]&0xff, codes[i+3]&0xff, codes[i+4]&0xff)
+				i += 4
+			} else {
+				return
+			}
+			if c == 38 {
+				s.fg = color
+			} else {
+				s.bg = color
+			}
+		}
+	}
+}
+
+// span is a run of text in one style
+type span struct {
+	style string
+	text  []rune
+}
+
+// renderHTML turns terminal output into a standalone HTML page. Colors and
+// text attributes are kept; there is no screen model, so cursor movement,
+// erase and scrolling sequences are dropped. A bare CR discards the line
+// written so far, which shows the final state of progress bars and
+// spinners but not of full-screen applications.
+func renderHTML(data []byte, title string) []byte {
+	var body strings.Builder
+	var line []span
+	var state sgrState
+
+	flushLine := func() {
+		for _, sp := range line {
+			text := html.EscapeString(string(sp.text))
+			if sp.style == "" {
+				body.WriteString(text)
+			} else {
+				fmt.Fprintf(&body, `<span style="%s">%s</span>`, sp.style, text)
+			}
+		}
+		body.WriteByte('\n')
+		line = line[:0]
+	}
+	addRune := func(r rune) {
+		style := state.css()
+		if n := len(line); n > 0 && line[n-1].style == style {
+			line[n-1].text = append(line[n-1].text, r)
+			return
+		}
+		line = append(line, span{style: style, text: []rune{r}})
+	}
+
+	for i := 0; i < len(data); {
+		c := data[i]
+		switch {
+		case c == 0x1b:
+			i = skipEscape(data, i, &state)
+			continue
+		case c == '\n':
+			flushLine()
+		case c == '\r':
+			if i+1 < len(data) && data[i+1] != '\n' && data[i+1] != '\r' {
+				line = line[:0]
+			}
+		case c == '\b':
+			if n := len(line); n > 0 {
+				last := &line[n-1]
+				last.text = last.text[:len(last.text)-1]
+				if len(last.text) == 0 {
+					line = line[:n-1]
+				}
+			}
+		case c == '\t':
+			addRune('\t')
+		case c < 0x20 || c == 0x7f:
+			// Other control characters have no visible form
+		default:
+			r, size := utf8.DecodeRune(data[i:])
+			addRune(r)
+			i += size
+			continue
+		}
+		i++
+	}
+	if len(line) > 0 {
+		flushLine()
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%s</title>
+<style>
+body { margin: 0; background: #1e1e1e; }
+pre { margin: 0; padding: 1em; color: #d4d4d4; font: 14px/1.3 Consolas, "DejaVu Sans Mono", monospace; white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<pre>%s</pre>
+</body>
+</html>
+`, html.EscapeString(title), body.String())
+	return out.Bytes()
+}
+
+// skipEscape consumes the escape sequence starting at data[i], applying it
+// to state if it is SGR, and returns the index just past it
+func skipEscape(data []byte, i int, state *sgrState) int {
+	i++ // ESC
+	if i >= len(data) {
+		return i
+	}
+	switch data[i] {
+	case '[': // CSI: parameters, intermediates, final byte
+		start := i + 1
+		for i = start; i < len(data) && (data[i] < 0x40 || data[i] > 0x7e); i++ {
+		}
+		if i < len(data) && data[i] == 'm' {
+			state.apply(string(data[start:i]))
+		}
+		return i + 1
+	case ']', 'P', '_', '^': // String sequences end with BEL or ESC \
+		for i++; i < len(data); i++ {
+			if data[i] == 0x07 {
+				return i + 1
+			}
+			if data[i] == 0x1b && i+1 < len(data) && data[i+1] == '\\' {
+				return i + 2
+			}
+		}
+		return i
+	case '(', ')', '*', '+', '#': // Charset designation and similar
+		return i + 2
+	}
+	return i + 1
+}
+
+// writeTranscript renders the captured output to an HTML file
+func writeTranscript(path string, capture *Capture, config Config) error {
+	title := fmt.Sprintf("%s - %s", config.Host, time.Now().Format("2006-01-02 15:04"))
+	return os.WriteFile(path, renderHTML(capture.Bytes(), title), 0644)
+}
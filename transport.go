@@ -0,0 +1,237 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrTunnelExited is returned when a tunnel command dies with a failure status
+var ErrTunnelExited = errors.New("tunnel command exited")
+
+// dialTarget opens the connection to the configured host using the
+// transport selected on the command line. interactive allows prompting on
+// stdin, for -choose-address.
+func dialTarget(config Config, interactive bool) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	if config.SSHTunnel != "" {
+		conn, err = dialCommand(expandTunnel(config.SSHTunnel, config.Host, config.Port))
+	} else {
+		conn, err = dialTCP(config, newDialer(config), interactive)
+	}
+	if err != nil || !config.TLS {
+		return conn, err
+	}
+	return startTLS(conn, config)
+}
+
+// tcpKeepAlive sets the TCP keepalive period of conn, if it is a TCP
+// connection underneath. Tunnels and proxied links keep their own.
+func tcpKeepAlive(conn net.Conn, period time.Duration) {
+	if c, ok := conn.(*tls.Conn); ok {
+		conn = c.NetConn()
+	}
+	if c, ok := conn.(*net.TCPConn); ok {
+		c.SetKeepAlive(true)
+		c.SetKeepAlivePeriod(period)
+	}
+}
+
+// dialTCP connects with d, directly or through a proxy. With
+// -choose-address, a failure on one of several resolved addresses leads to
+// the next: the user picks it from a menu when interactive, otherwise they
+// are tried in order.
+func dialTCP(config Config, d Dialer, interactive bool) (net.Conn, error) {
+	if !config.ChooseAddress {
+		return d.Dial("tcp", net.JoinHostPort(config.Host, config.Port))
+	}
+	addrs, err := net.LookupHost(config.Host)
+	if err != nil {
+		return nil, err
+	}
+	tried := make(map[string]bool)
+	next := addrs[0]
+	for {
+		conn, err := d.Dial("tcp", net.JoinHostPort(next, config.Port))
+		if err == nil {
+			return conn, nil
+		}
+		tried[next] = true
+		if len(tried) == len(addrs) {
+			return nil, err
+		}
+		fmt.Fprintf(os.Stderr, "[-] %s\r\n", tr("Connection to %s failed: %v", next, err))
+
+		if interactive {
+			var ok bool
+			if next, ok = chooseAddress(addrs, tried); !ok {
+				return nil, err
+			}
+		} else {
+			for _, a := range addrs {
+				if !tried[a] {
+					next = a
+					break
+				}
+			}
+		}
+		fmt.Fprintf(os.Stderr, "[*] %s\r\n", tr("Trying %s...", next))
+	}
+}
+
+// chooseAddress asks which resolved address to try next. It reports false
+// if the user gives up.
+func chooseAddress(addrs []string, tried map[string]bool) (string, bool) {
+	fmt.Fprintln(os.Stderr, tr("%d addresses found:", len(addrs)))
+	for i, a := range addrs {
+		mark := ""
+		if tried[a] {
+			mark = " " + tr("(failed)")
+		}
+		fmt.Fprintf(os.Stderr, "  %d) %s%s\n", i+1, a, mark)
+	}
+	for {
+		fmt.Fprint(os.Stderr, tr("Address to try [1-%d, empty to give up]: ", len(addrs)))
+		line, err := readStdinLine()
+		line = strings.TrimSpace(line)
+		if err != nil || line == "" {
+			return "", false
+		}
+		if n, err := strconv.Atoi(line); err == nil && n >= 1 && n <= len(addrs) {
+			return addrs[n-1], true
+		}
+	}
+}
+
+// readStdinLine reads one line a byte at a time, so nothing typed after it
+// is left stranded in a buffer before the keyboard reader starts
+func readStdinLine() (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(b)
+		if n == 1 {
+			if b[0] == '\n' {
+				return string(line), nil
+			}
+			line = append(line, b[0])
+		}
+		if err != nil {
+			return string(line), err
+		}
+	}
+}
+
+// expandTunnel substitutes %h, %p and %% in a ProxyCommand-style template
+func expandTunnel(template, host, port string) string {
+	r := strings.NewReplacer("%h", host, "%p", port, "%%", "%")
+	return r.Replace(template)
+}
+
+// cmdConn is a net.Conn backed by a subprocess's stdin and stdout, which is
+// how ProxyCommand-style tunnels (e.g. "ssh -W %h:%p bastion") are used
+type cmdConn struct {
+	cmd    *exec.Cmd
+	stdin  *os.File // our write end of the child's stdin
+	stdout *os.File // our read end of the child's stdout
+	exited chan struct{}
+	err    error // exit status, valid once exited is closed
+}
+
+// dialCommand starts command through the shell and returns its pipes as a connection
+func dialCommand(command string) (net.Conn, error) {
+	inR, inW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		inR.Close()
+		inW.Close()
+		return nil, err
+	}
+
+	cmd := shellCommand(command)
+	cmd.Stdin = inR
+	cmd.Stdout = outW
+	cmd.Stderr = os.Stderr // let ssh report auth and host key problems
+	if err := cmd.Start(); err != nil {
+		inR.Close()
+		inW.Close()
+		outR.Close()
+		outW.Close()
+		return nil, fmt.Errorf("failed to start tunnel command: %v", err)
+	}
+	// The child owns these ends now
+	inR.Close()
+	outW.Close()
+
+	c := &cmdConn{cmd: cmd, stdin: inW, stdout: outR, exited: make(chan struct{})}
+	go func() {
+		c.err = cmd.Wait()
+		close(c.exited)
+	}()
+	return c, nil
+}
+
+func (c *cmdConn) Read(p []byte) (int, error) {
+	n, err := c.stdout.Read(p)
+	if err != nil && n == 0 {
+		// Prefer the tunnel's exit status over a bare EOF
+		select {
+		case <-c.exited:
+			if c.err != nil {
+				return 0, fmt.Errorf("%w: %v", ErrTunnelExited, c.err)
+			}
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	return n, err
+}
+
+func (c *cmdConn) Write(p []byte) (int, error) {
+	return c.stdin.Write(p)
+}
+
+// Close hangs up the tunnel and reaps the subprocess
+func (c *cmdConn) Close() error {
+	c.stdin.Close()
+	c.stdout.Close()
+	select {
+	case <-c.exited:
+	case <-time.After(time.Second):
+		c.cmd.Process.Kill()
+		<-c.exited
+	}
+	var exitErr *exec.ExitError
+	if c.err != nil && !errors.As(c.err, &exitErr) {
+		return c.err
+	}
+	return nil
+}
+
+func (c *cmdConn) LocalAddr() net.Addr  { return pipeAddr{} }
+func (c *cmdConn) RemoteAddr() net.Addr { return pipeAddr{} }
+
+func (c *cmdConn) SetDeadline(t time.Time) error {
+	if err := c.stdout.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.stdin.SetWriteDeadline(t)
+}
+
+func (c *cmdConn) SetReadDeadline(t time.Time) error  { return c.stdout.SetReadDeadline(t) }
+func (c *cmdConn) SetWriteDeadline(t time.Time) error { return c.stdin.SetWriteDeadline(t) }
+
+// pipeAddr is the placeholder address of a subprocess connection
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "tunnel" }
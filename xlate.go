@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// XlateTable maps single bytes to replacement sequences. Bytes without an
+// entry pass through unchanged; an empty sequence deletes the byte.
+type XlateTable struct {
+	mapped [256]bool
+	to     [256][]byte
+}
+
+// loadXlateTable reads a translation table. Each non-blank line holds a
+// source byte followed by zero or more replacement items, e.g.
+//
+//	# Old terminal server sends DEL for backspace and bare CR for newline
+//	del      ctrl-h
+//	enter    "\r\n"
+//	0x00     -
+//
+// Bytes are written as in -block-keys (ctrl-x, esc, tab, enter, backspace,
+// 0xNN) or as a single literal character. Replacement items may also be
+// quoted strings with \e, \r, \n, \t, \\ and \xNN escapes; "-" alone means
+// drop the byte.
+func loadXlateTable(path string) (*XlateTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	t := &XlateTable{}
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := t.parseLine(line); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, lineNo, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// parseLine adds one "from to..." entry
+func (t *XlateTable) parseLine(line string) error {
+	items, err := splitXlateItems(line)
+	if err != nil {
+		return err
+	}
+	from, err := parseXlateByte(items[0])
+	if err != nil {
+		return err
+	}
+	if t.mapped[from] {
+		return fmt.Errorf("byte 0x%02x mapped twice", from)
+	}
+
+	var to []byte
+	rest := items[1:]
+	if len(rest) == 0 {
+		return fmt.Errorf("no replacement for 0x%02x (use - to drop it)", from)
+	}
+	if len(rest) == 1 && rest[0] == "-" {
+		rest = nil
+	}
+	for _, item := range rest {
+		if strings.HasPrefix(item, `"`) {
+			seq, err := unescape(item[1 : len(item)-1])
+			if err != nil {
+				return err
+			}
+			to = append(to, seq...)
+			continue
+		}
+		b, err := parseXlateByte(item)
+		if err != nil {
+			return err
+		}
+		to = append(to, b)
+	}
+	t.mapped[from] = true
+	t.to[from] = to
+	return nil
+}
+
+// splitXlateItems splits on whitespace, keeping quoted strings whole
+func splitXlateItems(line string) ([]string, error) {
+	var items []string
+	for line = strings.TrimSpace(line); line != ""; line = strings.TrimSpace(line) {
+		if line[0] == '"' {
+			end := 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				return nil, fmt.Errorf("unterminated string %s", line)
+			}
+			items = append(items, line[:end+1])
+			line = line[end+1:]
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			break // Trailing comment
+		}
+		end := strings.IndexAny(line, " \t")
+		if end < 0 {
+			end = len(line)
+		}
+		items = append(items, line[:end])
+		line = line[end:]
+	}
+	if len(items) > 0 && strings.HasPrefix(items[0], `"`) {
+		return nil, fmt.Errorf("source must be a single byte, not a string")
+	}
+	return items, nil
+}
+
+// parseXlateByte accepts a key name, 0xNN or one literal character
+func parseXlateByte(s string) (byte, error) {
+	if len(s) == 1 {
+		return s[0], nil
+	}
+	return parseKey(strings.ToLower(s))
+}
+
+// apply returns b translated through the table
+func (t *XlateTable) apply(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for _, c := range b {
+		if t.mapped[c] {
+			out = append(out, t.to[c]...)
+		} else {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// xlateWriter translates the server's stream before it is displayed
+type xlateWriter struct {
+	w     io.Writer
+	table *XlateTable
+}
+
+func (x *xlateWriter) Write(p []byte) (int, error) {
+	if _, err := x.w.Write(x.table.apply(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadSampleXlateTable(t *testing.T) {
+	table, err := loadXlateTable("testdata/sample.xlate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(table.apply([]byte("ab\x7fc\r\x00\x1bz")))
+	if want := "ab\bc\r\n\x1b[Az"; got != want {
+		t.Errorf("apply = %q, want %q", got, want)
+	}
+}
+
+func TestXlateTableErrors(t *testing.T) {
+	tests := []struct {
+		table string
+		err   string
+	}{
+		{"del ctrl-h\ndel ctrl-?", "sample.xlate:2: byte 0x7f mapped twice"},
+		{"del", "sample.xlate:1: no replacement for 0x7f"},
+		{`"ab" x`, "source must be a single byte"},
+		{`cr "\r`, "unterminated string"},
+		{"nosuchkey x", "sample.xlate:1:"},
+		{`a "\q"`, "sample.xlate:1:"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "sample.xlate")
+		os.WriteFile(path, []byte(tt.table), 0644)
+		_, err := loadXlateTable(path)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("table %q: error %v, want one containing %q", tt.table, err, tt.err)
+		}
+	}
+}
+
+func TestXlateApplyLeavesUnmappedBytes(t *testing.T) {
+	var table XlateTable
+	if err := table.parseLine("a b"); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(table.apply([]byte("banana\xff"))); got != "bbnbnb\xff" {
+		t.Errorf("apply = %q", got)
+	}
+}
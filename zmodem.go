@@ -0,0 +1,639 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Zmodem framing bytes
+const (
+	zPad   = '*'
+	zDLE   = 0x18 // Also CAN; five in a row abort the transfer
+	zBin   = 'A'  // Binary header, CRC16
+	zHex   = 'B'  // Hex header, CRC16
+	zBin32 = 'C'  // Binary header, CRC32
+)
+
+// Zmodem frame types
+const (
+	zRQINIT  = 0
+	zRINIT   = 1
+	zSINIT   = 2
+	zACK     = 3
+	zFILE    = 4
+	zSKIP    = 5
+	zNAK     = 6
+	zABORT   = 7
+	zFIN     = 8
+	zRPOS    = 9
+	zDATA    = 10
+	zEOF     = 11
+	zFERR    = 12
+	zFREECNT = 17
+	zCOMMAND = 18
+)
+
+// Subpacket terminators (following ZDLE) and escaped rubouts
+const (
+	zCRCE = 'h' // End of frame, header follows
+	zCRCG = 'i' // Frame continues nonstop
+	zCRCQ = 'j' // Frame continues, ZACK expected
+	zCRCW = 'k' // End of frame, ZACK expected
+	zRUB0 = 'l' // 0x7f
+	zRUB1 = 'm' // 0xff
+)
+
+// ZRINIT capability flags
+const (
+	zCanFDX  = 0x01 // Full duplex
+	zCanOVIO = 0x02 // Can receive data during disk I/O
+	zCanFC32 = 0x20 // Can use CRC32
+)
+
+const (
+	zHexHeaderLen  = 16    // ZDLE 'B' + 14 hex digits
+	zMaxSubpacket  = 65536 // Far above the 1K/8K senders actually use
+	zMaxGarbage    = 8192  // Non-header bytes tolerated while waiting for a header
+	zMaxHeaderErrs = 10
+)
+
+var (
+	errZCancelled = errors.New("transfer cancelled by sender")
+	errZBadCRC    = errors.New("bad CRC")
+	errZGarbage   = errors.New("sender stopped talking Zmodem")
+)
+
+// zmodemTrigger starts every ZRQINIT hex header ("**\x18B00..."). CAN never
+// occurs in ordinary text, so this is a safe thing to look for; the full
+// header and its CRC are still checked before we take over the stream.
+var zmodemTrigger = []byte{zDLE, zHex, '0', '0'}
+
+// ZmodemReceiver watches the decoded server stream for a Zmodem send and
+// receives the offered files into Dir
+type ZmodemReceiver struct {
+	Dir string
+	w   io.Writer // back to the server
+
+	r      *bufio.Reader
+	crc32  bool // the current frame uses 32-bit CRCs
+	file   *os.File
+	path   string
+	size   int64
+	offset int64
+	buf    []byte
+}
+
+// NewZmodemReceiver returns a receiver that answers the sender through w
+func NewZmodemReceiver(dir string, w io.Writer) *ZmodemReceiver {
+	return &ZmodemReceiver{Dir: dir, w: w}
+}
+
+// Pump copies src to dst like io.Copy, except that a detected Zmodem
+// transfer is diverted to the receiver instead of the screen
+func (z *ZmodemReceiver) Pump(src io.Reader, dst io.Writer) error {
+	in := &prefixReader{r: src}
+	buf := make([]byte, 32*1024)
+	held := 0
+	for {
+		n, err := in.Read(buf[held:])
+		data := buf[:held+n]
+		held = 0
+
+		for len(data) > 0 {
+			i := bytes.Index(data, zmodemTrigger)
+			if i < 0 {
+				// Hold back what could be the start of a split trigger, with
+				// any ZPADs before it
+				keep := partialSuffix(data, zmodemTrigger)
+				for keep > 0 && keep < len(data) && data[len(data)-keep-1] == zPad {
+					keep++
+				}
+				if _, werr := dst.Write(data[:len(data)-keep]); werr != nil {
+					return werr
+				}
+				held = copy(buf, data[len(data)-keep:])
+				break
+			}
+			if len(data)-i < zHexHeaderLen {
+				// Need the whole header before deciding. The ZPADs in front
+				// wait with it, so they can be dropped if it is one.
+				k := i
+				for k > 0 && data[k-1] == zPad {
+					k--
+				}
+				if _, werr := dst.Write(data[:k]); werr != nil {
+					return werr
+				}
+				held = copy(buf, data[k:])
+				break
+			}
+			if !isZRQINIT(data[i : i+zHexHeaderLen]) {
+				if _, werr := dst.Write(data[:i+1]); werr != nil {
+					return werr
+				}
+				data = data[i+1:]
+				continue
+			}
+
+			// Drop the ZPAD characters that lead into the header
+			if _, werr := dst.Write(bytes.TrimRight(data[:i], "*")); werr != nil {
+				return werr
+			}
+			in.unread(append([]byte{zPad}, data[i:]...))
+			z.receive(in)
+			data = nil
+		}
+
+		if err != nil {
+			// The stream ended on what looked like the start of a trigger
+			if held > 0 {
+				if _, werr := dst.Write(buf[:held]); werr != nil {
+					return werr
+				}
+			}
+			return err
+		}
+	}
+}
+
+// receive runs one Zmodem session, then hands unused bytes back to in
+func (z *ZmodemReceiver) receive(in *prefixReader) {
+	z.r = bufio.NewReader(in)
+	fmt.Printf("\r\n[*] %s\r\n", tr("Zmodem transfer detected, receiving into %s", z.Dir))
+
+	err := z.run()
+	z.discardFile()
+	if err != nil {
+		// Make sure the sender stops too
+		z.w.Write([]byte("\x18\x18\x18\x18\x18\x18\x18\x18\b\b\b\b\b\b\b\b"))
+		fmt.Printf("[-] %s\r\n", tr("Zmodem transfer failed: %v", err))
+	} else {
+		fmt.Printf("[*] %s\r\n", tr("Zmodem transfer finished, resuming session"))
+	}
+
+	if n := z.r.Buffered(); n > 0 {
+		rest, _ := z.r.Peek(n)
+		in.unread(append([]byte(nil), rest...))
+	}
+	z.r = nil
+}
+
+// run is the receiver state machine. The first header is the ZRQINIT
+// that Pump handed back, so the opening ZRINIT goes out in reply to it.
+func (z *ZmodemReceiver) run() error {
+	headerErrs := 0
+	for {
+		typ, hdr, err := z.readHeader()
+		if err == errZBadCRC {
+			headerErrs++
+			if headerErrs > zMaxHeaderErrs {
+				return errors.New("too many corrupt headers")
+			}
+			z.sendHex(zNAK, [4]byte{})
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		switch typ {
+		case zRQINIT:
+			err = z.sendRINIT()
+		case zSINIT:
+			// The attention string is only needed for interrupting the sender
+			if _, _, err = z.readSubpacket(); err == nil {
+				err = z.sendHex(zACK, [4]byte{})
+			}
+		case zFILE:
+			err = z.startFile()
+		case zDATA:
+			err = z.readData(zpos(hdr))
+		case zEOF:
+			if z.file != nil && zpos(hdr) == z.offset {
+				err = z.finishFile()
+				if err == nil {
+					err = z.sendRINIT()
+				}
+			}
+		case zFREECNT:
+			err = z.sendHex(zACK, [4]byte{})
+		case zFIN:
+			z.sendHex(zFIN, [4]byte{})
+			// The sender signs off with "OO"
+			for i := 0; i < 2; i++ {
+				if b, err := z.r.ReadByte(); err != nil || b != 'O' {
+					if err == nil {
+						z.r.UnreadByte()
+					}
+					break
+				}
+			}
+			return nil
+		case zCOMMAND:
+			return errors.New("refusing remote command request")
+		case zABORT, zFERR:
+			return errors.New("sender aborted the transfer")
+		default:
+			err = z.sendHex(zNAK, [4]byte{})
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (z *ZmodemReceiver) sendRINIT() error {
+	return z.sendHex(zRINIT, [4]byte{0, 0, 0, zCanFDX | zCanOVIO | zCanFC32})
+}
+
+// startFile handles ZFILE: parse the file info and open the output file
+func (z *ZmodemReceiver) startFile() error {
+	info, _, err := z.readSubpacket()
+	if err != nil {
+		if err == errZBadCRC {
+			return z.sendHex(zNAK, [4]byte{})
+		}
+		return err
+	}
+
+	name, rest, _ := bytes.Cut(info, []byte{0})
+	base := filepath.Base(strings.ReplaceAll(string(name), "\\", "/"))
+	if base == "." || base == ".." || base == "/" || base == "" {
+		fmt.Printf("[-] %s\r\n", tr("Zmodem: skipping file with unusable name %q", name))
+		return z.sendHex(zSKIP, [4]byte{})
+	}
+	z.size = -1
+	if fields := strings.Fields(string(bytes.TrimRight(rest, "\x00"))); len(fields) > 0 {
+		if v, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+			z.size = v
+		}
+	}
+
+	// A new ZFILE before ZEOF abandons the file in progress
+	z.discardFile()
+	if err := os.MkdirAll(z.Dir, 0755); err != nil {
+		return err
+	}
+	f, path, err := createUnique(filepath.Join(z.Dir, base))
+	if err != nil {
+		return err
+	}
+	z.file, z.path, z.offset = f, path, 0
+	fmt.Printf("[*] %s\r\n", tr("Zmodem: receiving %s (%d bytes)", filepath.Base(path), z.size))
+	return z.sendHex(zRPOS, zhdr(0))
+}
+
+// readData handles a ZDATA frame starting at pos
+func (z *ZmodemReceiver) readData(pos int64) error {
+	if z.file == nil {
+		return z.sendHex(zSKIP, [4]byte{})
+	}
+	if pos != z.offset {
+		// Out of sync; ask the sender to rewind to where we are
+		return z.sendHex(zRPOS, zhdr(z.offset))
+	}
+
+	for {
+		data, end, err := z.readSubpacket()
+		if err == errZBadCRC {
+			return z.sendHex(zRPOS, zhdr(z.offset))
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := z.file.Write(data); err != nil {
+			return err
+		}
+		z.offset += int64(len(data))
+
+		switch end {
+		case zCRCW:
+			return z.sendHex(zACK, zhdr(z.offset))
+		case zCRCQ:
+			if err := z.sendHex(zACK, zhdr(z.offset)); err != nil {
+				return err
+			}
+		case zCRCE:
+			return nil
+		}
+	}
+}
+
+// discardFile closes and removes a file that didn't arrive in full
+func (z *ZmodemReceiver) discardFile() {
+	if z.file == nil {
+		return
+	}
+	z.file.Close()
+	os.Remove(z.path)
+	fmt.Printf("[-] %s\r\n", tr("Zmodem: discarded incomplete %s", filepath.Base(z.path)))
+	z.file = nil
+}
+
+// finishFile closes the current file after ZEOF
+func (z *ZmodemReceiver) finishFile() error {
+	err := z.file.Close()
+	z.file = nil
+	if err != nil {
+		return err
+	}
+	fmt.Printf("[+] %s\r\n", tr("Zmodem: saved %s (%d bytes)", z.path, z.offset))
+	return nil
+}
+
+// readHeader skips to the next ZPAD..ZDLE sequence and decodes the header
+func (z *ZmodemReceiver) readHeader() (typ byte, hdr [4]byte, err error) {
+	pads, cans, garbage := 0, 0, 0
+	for {
+		b, err := z.r.ReadByte()
+		if err != nil {
+			return 0, hdr, err
+		}
+		if b == zDLE {
+			cans++
+			if cans >= 5 {
+				return 0, hdr, errZCancelled
+			}
+			if pads > 0 {
+				break
+			}
+			continue
+		}
+		cans = 0
+		if b == zPad {
+			pads++
+			continue
+		}
+		pads = 0
+		garbage++
+		if garbage > zMaxGarbage {
+			return 0, hdr, errZGarbage
+		}
+	}
+
+	format, err := z.r.ReadByte()
+	if err != nil {
+		return 0, hdr, err
+	}
+	var raw []byte
+	switch format {
+	case zHex:
+		z.crc32 = false
+		raw, err = z.readHexHeader()
+	case zBin:
+		z.crc32 = false
+		raw, err = z.readBinHeader(2)
+	case zBin32:
+		z.crc32 = true
+		raw, err = z.readBinHeader(4)
+	default:
+		return 0, hdr, errZBadCRC
+	}
+	if err != nil {
+		return 0, hdr, err
+	}
+	copy(hdr[:], raw[1:5])
+	return raw[0], hdr, nil
+}
+
+// readHexHeader reads the 14 hex digits after ZDLE 'B' plus the trailing CR LF
+func (z *ZmodemReceiver) readHexHeader() ([]byte, error) {
+	digits := make([]byte, zHexHeaderLen-2)
+	if _, err := io.ReadFull(z.r, digits); err != nil {
+		return nil, err
+	}
+	raw, ok := decodeHexHeader(digits)
+	if !ok {
+		return nil, errZBadCRC
+	}
+	// Swallow the CR LF (and XON) that trail a hex header
+	for i := 0; i < 3 && z.r.Buffered() > 0; i++ {
+		b, _ := z.r.ReadByte()
+		if c := b & 0x7f; c != '\r' && c != '\n' && c != 0x11 {
+			z.r.UnreadByte()
+			break
+		}
+	}
+	return raw, nil
+}
+
+// readBinHeader reads a ZDLE-encoded binary header with a crcLen-byte CRC
+func (z *ZmodemReceiver) readBinHeader(crcLen int) ([]byte, error) {
+	raw := make([]byte, 5+crcLen)
+	for i := range raw {
+		c, err := z.zdlRead()
+		if err != nil {
+			return nil, err
+		}
+		if c > 0xff {
+			return nil, errZBadCRC
+		}
+		raw[i] = byte(c)
+	}
+	if !checkCRC(raw[:5], raw[5:]) {
+		return nil, errZBadCRC
+	}
+	return raw, nil
+}
+
+// readSubpacket reads one data subpacket and reports how it was terminated
+func (z *ZmodemReceiver) readSubpacket() (data []byte, end byte, err error) {
+	data = z.buf[:0]
+	for {
+		c, err := z.zdlRead()
+		if err != nil {
+			return nil, 0, err
+		}
+		if c > 0xff {
+			end = byte(c)
+			break
+		}
+		data = append(data, byte(c))
+		if len(data) > zMaxSubpacket {
+			return nil, 0, errors.New("oversized data subpacket")
+		}
+	}
+	z.buf = data
+
+	crcLen := 2
+	if z.crc32 {
+		crcLen = 4
+	}
+	crc := make([]byte, crcLen)
+	for i := range crc {
+		c, err := z.zdlRead()
+		if err != nil {
+			return nil, 0, err
+		}
+		crc[i] = byte(c)
+	}
+	if !checkCRC(append(data, end), crc) {
+		return nil, 0, errZBadCRC
+	}
+	return data, end, nil
+}
+
+// zdlRead returns the next ZDLE-decoded byte. Subpacket terminators are
+// returned as 0x100|terminator; unescaped XON/XOFF are flow control noise.
+func (z *ZmodemReceiver) zdlRead() (int, error) {
+	for {
+		b, err := z.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case 0x11, 0x13, 0x91, 0x93:
+			continue
+		case zDLE:
+		default:
+			return int(b), nil
+		}
+
+		cans := 1
+		for {
+			c, err := z.r.ReadByte()
+			if err != nil {
+				return 0, err
+			}
+			switch {
+			case c == zDLE:
+				cans++
+				if cans >= 5 {
+					return 0, errZCancelled
+				}
+			case c == 0x11 || c == 0x13 || c == 0x91 || c == 0x93:
+			case c >= zCRCE && c <= zCRCW:
+				return 0x100 | int(c), nil
+			case c == zRUB0:
+				return 0x7f, nil
+			case c == zRUB1:
+				return 0xff, nil
+			case c&0x60 == 0x40:
+				return int(c ^ 0x40), nil
+			default:
+				return 0, errZBadCRC
+			}
+		}
+	}
+}
+
+// sendHex sends a hex header, which is all a receiver ever needs to send
+func (z *ZmodemReceiver) sendHex(typ byte, hdr [4]byte) error {
+	raw := []byte{typ, hdr[0], hdr[1], hdr[2], hdr[3]}
+	crc := crc16(raw)
+	raw = append(raw, byte(crc>>8), byte(crc))
+
+	msg := append([]byte{zPad, zPad, zDLE, zHex}, hex.EncodeToString(raw)...)
+	msg = append(msg, '\r', '\n')
+	if typ != zFIN && typ != zACK {
+		msg = append(msg, 0x11) // XON
+	}
+	_, err := z.w.Write(msg)
+	return err
+}
+
+// isZRQINIT reports whether b (starting at ZDLE) is a valid ZRQINIT hex header
+func isZRQINIT(b []byte) bool {
+	raw, ok := decodeHexHeader(b[2:zHexHeaderLen])
+	return ok && raw[0] == zRQINIT
+}
+
+// decodeHexHeader decodes and CRC-checks the 14 hex digits of a hex header
+func decodeHexHeader(digits []byte) ([]byte, bool) {
+	raw := make([]byte, 7)
+	if _, err := hex.Decode(raw, bytes.ToLower(digits)); err != nil {
+		return nil, false
+	}
+	return raw, checkCRC(raw[:5], raw[5:])
+}
+
+// checkCRC verifies a CRC16 (2 bytes, big endian) or CRC32 (4 bytes, little endian)
+func checkCRC(data, crc []byte) bool {
+	if len(crc) == 4 {
+		v := crc32.ChecksumIEEE(data)
+		return crc[0] == byte(v) && crc[1] == byte(v>>8) && crc[2] == byte(v>>16) && crc[3] == byte(v>>24)
+	}
+	v := crc16(data)
+	return crc[0] == byte(v>>8) && crc[1] == byte(v)
+}
+
+// crc16 is the XMODEM CRC-16 (CCITT polynomial, zero initial value)
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// zhdr encodes a file position into header bytes (ZP0 is least significant)
+func zhdr(pos int64) [4]byte {
+	return [4]byte{byte(pos), byte(pos >> 8), byte(pos >> 16), byte(pos >> 24)}
+}
+
+// zpos decodes a file position from header bytes
+func zpos(hdr [4]byte) int64 {
+	return int64(hdr[0]) | int64(hdr[1])<<8 | int64(hdr[2])<<16 | int64(hdr[3])<<24
+}
+
+// createUnique creates path, or path.1, path.2, ... if it already exists
+func createUnique(path string) (*os.File, string, error) {
+	candidate := path
+	for i := 1; ; i++ {
+		f, err := os.OpenFile(candidate, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			return f, candidate, nil
+		}
+		if !os.IsExist(err) || i > 999 {
+			return nil, "", err
+		}
+		candidate = fmt.Sprintf("%s.%d", path, i)
+	}
+}
+
+// partialSuffix returns the length of the longest suffix of data that is a
+// proper prefix of pattern
+func partialSuffix(data, pattern []byte) int {
+	for k := len(pattern) - 1; k > 0; k-- {
+		if len(data) >= k && bytes.HasSuffix(data, pattern[:k]) {
+			return k
+		}
+	}
+	return 0
+}
+
+// prefixReader serves pushed-back bytes before reading from r
+type prefixReader struct {
+	buf []byte
+	r   io.Reader
+}
+
+func (p *prefixReader) Read(b []byte) (int, error) {
+	if len(p.buf) > 0 {
+		n := copy(b, p.buf)
+		p.buf = p.buf[n:]
+		return n, nil
+	}
+	return p.r.Read(b)
+}
+
+// unread pushes b in front of anything already pending
+func (p *prefixReader) unread(b []byte) {
+	p.buf = append(b, p.buf...)
+}
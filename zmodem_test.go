@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"hash/crc32"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// zEscape ZDLE-encodes b the way a sender must
+func zEscape(b []byte) []byte {
+	var out []byte
+	for _, c := range b {
+		switch c {
+		case zDLE, 0x11, 0x13, 0x91, 0x93:
+			out = append(out, zDLE, c^0x40)
+		default:
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// zHexFrame builds a hex header, as used for ZRQINIT, ZEOF and ZFIN
+func zHexFrame(typ byte, hdr [4]byte) []byte {
+	raw := []byte{typ, hdr[0], hdr[1], hdr[2], hdr[3]}
+	crc := crc16(raw)
+	raw = append(raw, byte(crc>>8), byte(crc))
+	frame := append([]byte{zPad, zPad, zDLE, zHex}, hex.EncodeToString(raw)...)
+	return append(frame, '\r', '\n', 0x11)
+}
+
+// zBinFrame builds a binary header with a CRC16 (zBin) or CRC32 (zBin32)
+func zBinFrame(format, typ byte, hdr [4]byte) []byte {
+	raw := []byte{typ, hdr[0], hdr[1], hdr[2], hdr[3]}
+	frame := []byte{zPad, zDLE, format}
+	return append(frame, zEscape(append(raw, zCRC(format, raw)...))...)
+}
+
+// zSubpacket builds a data subpacket with the CRC the header's format calls for
+func zSubpacket(format byte, data []byte, end byte) []byte {
+	out := append(zEscape(data), zDLE, end)
+	return append(out, zEscape(zCRC(format, append(append([]byte(nil), data...), end)))...)
+}
+
+func zCRC(format byte, b []byte) []byte {
+	if format == zBin32 {
+		v := crc32.ChecksumIEEE(b)
+		return []byte{byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24)}
+	}
+	v := crc16(b)
+	return []byte{byte(v >> 8), byte(v)}
+}
+
+// zSender plays sz at the other end of a pipe: it writes frames and waits
+// for the receiver's hex headers in between
+type zSender struct {
+	t       *testing.T
+	conn    net.Conn
+	replies chan [5]byte
+}
+
+func newZSender(t *testing.T, conn net.Conn) *zSender {
+	s := &zSender{t: t, conn: conn, replies: make(chan [5]byte, 16)}
+	go func() {
+		var in []byte
+		buf := make([]byte, 256)
+		for {
+			n, err := conn.Read(buf)
+			in = append(in, buf[:n]...)
+			for {
+				i := bytes.Index(in, []byte{zDLE, zHex})
+				if i < 0 || len(in)-i < zHexHeaderLen {
+					break
+				}
+				if raw, ok := decodeHexHeader(in[i+2 : i+zHexHeaderLen]); ok {
+					s.replies <- [5]byte(raw[:5])
+				}
+				in = in[i+zHexHeaderLen:]
+			}
+			if err != nil {
+				close(s.replies)
+				return
+			}
+		}
+	}()
+	return s
+}
+
+func (s *zSender) send(b []byte) {
+	s.t.Helper()
+	if _, err := s.conn.Write(b); err != nil {
+		s.t.Fatalf("sender write: %v", err)
+	}
+}
+
+// expect waits for a reply of the given type and returns its position
+func (s *zSender) expect(typ byte) int64 {
+	s.t.Helper()
+	select {
+	case r, ok := <-s.replies:
+		if !ok {
+			s.t.Fatalf("receiver hung up waiting for frame %d", typ)
+		}
+		if r[0] != typ {
+			s.t.Fatalf("receiver sent frame %d, want %d", r[0], typ)
+		}
+		return zpos([4]byte{r[1], r[2], r[3], r[4]})
+	case <-time.After(2 * time.Second):
+		s.t.Fatalf("no frame %d from the receiver", typ)
+	}
+	return 0
+}
+
+// zfile is the ZFILE info subpacket: name, NUL, size
+func zfile(name string, size int) []byte {
+	return []byte(name + "\x00" + strconv.Itoa(size) + " 0 0\x00")
+}
+
+// pumpZmodem runs Pump on the client end of a pipe, receiving into a
+// temporary directory, and returns the sender and what reached the screen
+func pumpZmodem(t *testing.T) (dir string, s *zSender, screen func() string) {
+	t.Helper()
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close(); server.Close() })
+	dir = t.TempDir()
+	var out bytes.Buffer
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		NewZmodemReceiver(dir, client).Pump(client, &out)
+	}()
+	return dir, newZSender(t, server), func() string {
+		server.Close()
+		<-done
+		return out.String()
+	}
+}
+
+func TestZmodemReceive(t *testing.T) {
+	dir, s, screen := pumpZmodem(t)
+	payload := []byte("hello\x18world\x11\xff\x7f\r\n")
+
+	s.send(append([]byte("before "), zHexFrame(zRQINIT, [4]byte{})...))
+	s.expect(zRINIT)
+	s.send(append(zBinFrame(zBin, zFILE, [4]byte{}), zSubpacket(zBin, zfile("notes.txt", len(payload)), zCRCW)...))
+	if pos := s.expect(zRPOS); pos != 0 {
+		t.Fatalf("ZRPOS %d, want 0", pos)
+	}
+	s.send(append(zBinFrame(zBin32, zDATA, zhdr(0)), zSubpacket(zBin32, payload, zCRCE)...))
+	s.send(zHexFrame(zEOF, zhdr(int64(len(payload)))))
+	s.expect(zRINIT)
+	s.send(zHexFrame(zFIN, [4]byte{}))
+	s.expect(zFIN)
+	s.send([]byte("OOafter"))
+
+	out := screen()
+	if out != "before after" {
+		t.Errorf("screen = %q, want the text around the transfer", out)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "notes.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Errorf("file = %q, want %q", got, payload)
+	}
+}
+
+// A corrupted data subpacket is asked for again from where we are
+func TestZmodemBadCRC(t *testing.T) {
+	dir, s, screen := pumpZmodem(t)
+	s.send(zHexFrame(zRQINIT, [4]byte{}))
+	s.expect(zRINIT)
+	s.send(append(zBinFrame(zBin, zFILE, [4]byte{}), zSubpacket(zBin, zfile("a.bin", 8), zCRCW)...))
+	s.expect(zRPOS)
+
+	// First four bytes arrive intact, the next subpacket's CRC is wrong
+	good := zSubpacket(zBin, []byte("abcd"), zCRCG)
+	bad := zSubpacket(zBin, []byte("efgh"), zCRCE)
+	bad[len(bad)-1] ^= 0x01
+	s.send(append(append(zBinFrame(zBin, zDATA, zhdr(0)), good...), bad...))
+	if pos := s.expect(zRPOS); pos != 4 {
+		t.Fatalf("ZRPOS %d after a bad CRC, want 4", pos)
+	}
+	s.send(append(zBinFrame(zBin, zDATA, zhdr(4)), zSubpacket(zBin, []byte("efgh"), zCRCE)...))
+	s.send(zHexFrame(zEOF, zhdr(8)))
+	s.expect(zRINIT)
+	s.send(zHexFrame(zFIN, [4]byte{}))
+	s.expect(zFIN)
+	s.send([]byte("OO"))
+	screen()
+
+	if got, _ := os.ReadFile(filepath.Join(dir, "a.bin")); string(got) != "abcdefgh" {
+		t.Errorf("file = %q, want \"abcdefgh\"", got)
+	}
+}
+
+// A second ZFILE before ZEOF drops the first file rather than leaking it
+func TestZmodemSecondZFILE(t *testing.T) {
+	dir, s, screen := pumpZmodem(t)
+	s.send(zHexFrame(zRQINIT, [4]byte{}))
+	s.expect(zRINIT)
+	s.send(append(zBinFrame(zBin, zFILE, [4]byte{}), zSubpacket(zBin, zfile("first", 100), zCRCW)...))
+	s.expect(zRPOS)
+	s.send(append(zBinFrame(zBin, zFILE, [4]byte{}), zSubpacket(zBin, zfile("second", 2), zCRCW)...))
+	s.expect(zRPOS)
+	s.send(append(zBinFrame(zBin, zDATA, zhdr(0)), zSubpacket(zBin, []byte("ok"), zCRCE)...))
+	s.send(zHexFrame(zEOF, zhdr(2)))
+	s.expect(zRINIT)
+	s.send(zHexFrame(zFIN, [4]byte{}))
+	s.expect(zFIN)
+	s.send([]byte("OO"))
+	screen()
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 || entries[0].Name() != "second" {
+		t.Errorf("directory holds %v, want just \"second\"", entries)
+	}
+}
+
+// chunkReader returns one chunk per Read
+type chunkReader struct{ chunks [][]byte }
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	if len(c.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, c.chunks[0])
+	c.chunks = c.chunks[1:]
+	return n, nil
+}
+
+// pumpChunks runs Pump over chunks and returns the screen output and
+// whatever the receiver sent back
+func pumpChunks(t *testing.T, chunks ...[]byte) (string, string) {
+	t.Helper()
+	var screen, replies bytes.Buffer
+	z := NewZmodemReceiver(t.TempDir(), &replies)
+	if err := z.Pump(&chunkReader{chunks: chunks}, &screen); err != io.EOF {
+		t.Fatalf("Pump = %v, want io.EOF", err)
+	}
+	return screen.String(), replies.String()
+}
+
+// Text that merely looks like the start of a ZRQINIT is shown as is
+func TestZmodemFalseTrigger(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+	}{
+		{"bad CRC", []string{"x **\x18B00000000000001\r\ny"}},
+		{"not hex", []string{"x **\x18B00zzzzzzzzzzzz\r\ny"}},
+		{"bare trigger at the end", []string{"x **\x18B"}},
+		{"split bare trigger", []string{"x **\x18", "B0"}},
+		{"wrong frame type", []string{string(zHexFrame(zRINIT, [4]byte{}))}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var chunks [][]byte
+			var want string
+			for _, c := range tt.chunks {
+				chunks = append(chunks, []byte(c))
+				want += c
+			}
+			screen, replies := pumpChunks(t, chunks...)
+			if screen != want {
+				t.Errorf("screen = %q, want %q", screen, want)
+			}
+			if replies != "" {
+				t.Errorf("receiver answered %q to a false trigger", replies)
+			}
+		})
+	}
+}
+
+// A ZRQINIT split across reads is still recognised
+func TestZmodemTriggerSplit(t *testing.T) {
+	frame := zHexFrame(zRQINIT, [4]byte{})
+	// From just past the ZPADs: pads alone at the end of a read are shown,
+	// as output ending in '*' can't wait for a trigger that may not come
+	for cut := 3; cut < zHexHeaderLen+2; cut++ {
+		first := append([]byte("text"), frame[:cut]...)
+		// The sender gives up at once, so the session ends right away
+		rest := append(append([]byte(nil), frame[cut:]...), bytes.Repeat([]byte{zDLE}, 5)...)
+		screen, replies := pumpChunks(t, first, rest)
+		if screen != "text" {
+			t.Errorf("cut %d: screen = %q, want \"text\"", cut, screen)
+		}
+		if !bytes.Contains([]byte(replies), []byte{zDLE, zHex, '0', '1'}) {
+			t.Errorf("cut %d: no ZRINIT in %q", cut, replies)
+		}
+	}
+}